@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/liliang-cn/greenlight/internal/data"
+	"github.com/liliang-cn/greenlight/internal/validator"
+)
+
+// adminListUsersHandler 按姓名或邮箱, 激活状态和权限搜索用户并分页
+func (app *application) adminListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.UserSearch
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Query = app.readString(qs, "q", "")
+	input.Activated = app.readBool(qs, "activated", v)
+	input.Permission = app.readString(qs, "permission", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(input.UserSearch, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// adminShowUserHandler 获取用户的信息和权限
+func (app *application) adminShowUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminReadUser(w, r)
+	if !ok {
+		return
+	}
+
+	app.adminWriteUser(w, r, user)
+}
+
+// adminUpdateUserActivatedHandler 激活或停用用户, 不能停用自己
+// 停用的用户不能登录, 也不能通过激活 Token 恢复, 只能由管理员重新激活
+func (app *application) adminUpdateUserActivatedHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminReadUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Activated *bool `json:"activated"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Activated != nil, "activated", "must be provided")
+	v.Check(input.Activated == nil || *input.Activated || user.ID != app.contextGetUser(r).ID, "activated", "must not deactivate your own account")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// 停用的同时标记为 suspended, 避免用户通过重新发送激活邮件恢复账号
+	if user.Activated != *input.Activated || user.Suspended == *input.Activated {
+		user.Activated = *input.Activated
+		user.Suspended = !*input.Activated
+
+		err = app.models.Users.Update(user)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	// 停用后用户所有的 Token 立即失效
+	if user.Suspended {
+		for _, scope := range data.TokenScopes {
+			err = app.models.Tokens.DeleteAllForUser(scope, user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+	}
+
+	app.adminWriteUser(w, r, user)
+}
+
+// adminDeleteUserTokensHandler 使用户的 Token 立即失效, 可以用 scope 参数只删除某种用途的 Token
+func (app *application) adminDeleteUserTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminReadUser(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+
+	scope := app.readString(r.URL.Query(), "scope", "")
+	if v.Check(scope == "" || validator.In(scope, data.TokenScopes...), "scope", "invalid token scope"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	scopes := data.TokenScopes
+	if scope != "" {
+		scopes = []string{scope}
+	}
+
+	for _, scope := range scopes {
+		err := app.models.Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "tokens successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// adminGrantPermissionsHandler 给用户添加权限, 已有的权限会被忽略
+func (app *application) adminGrantPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminReadUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Codes []string `json:"codes"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	codes, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Codes) > 0, "codes", "must contain at least 1 permission code")
+	for _, code := range input.Codes {
+		v.Check(codes.Include(code), "codes", "must only contain existing permission codes")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Permissions.AddForUser(user.ID, input.Codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.adminWriteUser(w, r, user)
+}
+
+// adminRevokePermissionHandler 移除用户的权限, 不能移除自己的 users:admin 权限
+// 权限代码不存在时返回 422, 用户没有这个权限时返回 404
+func (app *application) adminRevokePermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminReadUser(w, r)
+	if !ok {
+		return
+	}
+
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	codes, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(codes.Include(code), "code", "must be an existing permission code")
+	v.Check(code != "users:admin" || user.ID != app.contextGetUser(r).ID, "code", "must not revoke your own users:admin permission")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// 用户没有这个权限时返回 404
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !permissions.Include(code) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Permissions.RemoveForUser(user.ID, code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.adminWriteUser(w, r, user)
+}
+
+// adminReadUser 读取 URL 中 id 对应的用户, 出错时已经写入了错误响应
+func (app *application) adminReadUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// adminWriteUser 返回用户的信息和当前的权限
+func (app *application) adminWriteUser(w http.ResponseWriter, r *http.Request, user *data.User) {
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
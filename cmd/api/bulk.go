@@ -0,0 +1,283 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/liliang-cn/greenlight/internal/data"
+	"github.com/liliang-cn/greenlight/internal/validator"
+)
+
+// bulkMovieLimit 一次批量操作最多影响的电影数量
+const bulkMovieLimit = 1000
+
+// bulkTarget 批量操作选择的电影
+type bulkTarget struct {
+	IDs    []int64
+	Search data.MovieSearch
+	DryRun bool
+}
+
+// bulkMovie 批量操作结果中的一部电影
+type bulkMovie struct {
+	ID      int64                 `json:"id"`
+	Title   string                `json:"title"`
+	Version int32                 `json:"version"`
+	Changes []data.RevisionChange `json:"changes,omitempty"`
+}
+
+// bulkFailure 批量修改后未通过校验的电影
+type bulkFailure struct {
+	ID     int64             `json:"id"`
+	Errors map[string]string `json:"errors"`
+}
+
+// readBulkTarget 从 querystring 中读取批量操作的电影: ids 或者与列表接口相同的搜索条件, 以及 dry_run
+// 两者都没有提供时不会匹配所有电影, 而是返回校验错误
+func (app *application) readBulkTarget(r *http.Request, v *validator.Validator) bulkTarget {
+	qs := r.URL.Query()
+
+	var target bulkTarget
+
+	for _, s := range app.readCSV(qs, "ids", []string{}) {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 1 {
+			v.AddError("ids", "must only contain positive integers")
+			continue
+		}
+		target.IDs = append(target.IDs, id)
+	}
+
+	target.Search = app.readMovieSearch(qs, v)
+	target.Search.UserID = app.contextGetUser(r).ID
+
+	dryRun := app.readBool(qs, "dry_run", v)
+	target.DryRun = dryRun != nil && *dryRun
+
+	data.ValidateMovieSearch(v, target.Search)
+
+	v.Check(len(target.IDs) > 0 || !target.Search.Empty(), "ids", "must be provided unless filters are used")
+	v.Check(len(target.IDs) == 0 || target.Search.Empty(), "ids", "must not be combined with filters")
+	v.Check(len(target.IDs) <= bulkMovieLimit, "ids", "must not contain more than 1000 ids")
+
+	return target
+}
+
+// selectBulkMovies 在批量事务中锁定要操作的电影, 超过上限时返回 false 并发送错误响应
+func (app *application) selectBulkMovies(w http.ResponseWriter, r *http.Request, bulk *data.MovieBulk, target bulkTarget) ([]*data.Movie, bool) {
+	genres, err := app.models.Genres.Registry()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	target.Search.Genres = genres.Canonicalize(target.Search.Genres)
+
+	movies, err := bulk.Select(target.IDs, target.Search, bulkMovieLimit+1)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if len(movies) > bulkMovieLimit {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "the filters match more than 1000 movies, please narrow them down")
+		return nil, false
+	}
+
+	return movies, true
+}
+
+// missingIDs 返回 ids 中没有匹配到电影 (不存在或在回收站中) 的 id
+func missingIDs(ids []int64, movies []*data.Movie) []int64 {
+	found := make(map[int64]bool, len(movies))
+	for _, movie := range movies {
+		found[movie.ID] = true
+	}
+
+	missing := []int64{}
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+
+	return missing
+}
+
+// movieRevision 返回电影当前内容对应的修订, 用于比较修改前后的差异
+func movieRevision(movie *data.Movie) *data.MovieRevision {
+	return &data.MovieRevision{
+		MovieID: movie.ID,
+		Version: movie.Version,
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+	}
+}
+
+// bulkUpdateMoviesHandler 批量修改 ids 或搜索条件匹配的电影, 所有电影在同一个事务中修改
+// 任意一部电影修改后未通过校验时不修改任何电影; dry_run=true 时只返回将要发生的修改
+func (app *application) bulkUpdateMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Year         *int32        `json:"year"`
+		Runtime      *data.Runtime `json:"runtime"`
+		Genres       []string      `json:"genres"`
+		AddGenres    []string      `json:"add_genres"`
+		RemoveGenres []string      `json:"remove_genres"`
+	}
+
+	v := validator.New()
+
+	target := app.readBulkTarget(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	genres, err := app.models.Genres.Registry()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	changes := data.MovieChanges{
+		Year:         input.Year,
+		Runtime:      input.Runtime,
+		Genres:       input.Genres,
+		AddGenres:    genres.Canonicalize(input.AddGenres),
+		RemoveGenres: genres.Canonicalize(input.RemoveGenres),
+	}
+
+	if data.ValidateMovieChanges(v, changes); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	bulk, err := app.models.Movies.BeginBulk(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer bulk.Rollback()
+
+	movies, ok := app.selectBulkMovies(w, r, bulk, target)
+	if !ok {
+		return
+	}
+
+	results := make([]bulkMovie, 0, len(movies))
+	failures := []bulkFailure{}
+
+	for _, movie := range movies {
+		before := movieRevision(movie)
+
+		changes.Apply(movie)
+
+		v := validator.New()
+		if data.ValidateMovie(v, movie, genres); !v.Valid() {
+			failures = append(failures, bulkFailure{ID: movie.ID, Errors: v.Errors})
+			continue
+		}
+
+		results = append(results, bulkMovie{
+			ID:      movie.ID,
+			Title:   movie.Title,
+			Version: movie.Version,
+			Changes: data.DiffRevisions(before, movieRevision(movie)),
+		})
+	}
+
+	if len(failures) > 0 {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, envelope{"movies": failures})
+		return
+	}
+
+	if !target.DryRun {
+		for i, movie := range movies {
+			err = bulk.Update(movie)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			results[i].Version = movie.Version
+		}
+
+		err = bulk.Commit()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	env := envelope{"dry_run": target.DryRun, "matched": len(movies), "movies": results}
+	if len(target.IDs) > 0 {
+		env["missing_ids"] = missingIDs(target.IDs, movies)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// bulkDeleteMoviesHandler 批量将 ids 或搜索条件匹配的电影移入回收站, dry_run=true 时只返回将要删除的电影
+func (app *application) bulkDeleteMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	target := app.readBulkTarget(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	bulk, err := app.models.Movies.BeginBulk(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer bulk.Rollback()
+
+	movies, ok := app.selectBulkMovies(w, r, bulk, target)
+	if !ok {
+		return
+	}
+
+	results := make([]bulkMovie, len(movies))
+	for i, movie := range movies {
+		results[i] = bulkMovie{ID: movie.ID, Title: movie.Title, Version: movie.Version}
+	}
+
+	if !target.DryRun {
+		err = bulk.Delete(movies)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for i, movie := range movies {
+			results[i].Version = movie.Version
+		}
+
+		err = bulk.Commit()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	env := envelope{"dry_run": target.DryRun, "matched": len(movies), "movies": results}
+	if len(target.IDs) > 0 {
+		env["missing_ids"] = missingIDs(target.IDs, movies)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
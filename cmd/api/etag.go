@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/liliang-cn/greenlight/internal/data"
+)
+
+// movieETag 返回电影的 ETag, 以版本号开头
+// 评分变化不会增加版本号, 所以附加上评分信息, 保证内容变化时 ETag 也会变化
+func movieETag(movie *data.Movie) string {
+	return fmt.Sprintf(`"%d-%d-%s"`, movie.Version, movie.RatingCount, strconv.FormatFloat(movie.AverageRating, 'f', 2, 64))
+}
+
+// etagVersion 从 movieETag 生成的 ETag 中解析出版本号
+func etagVersion(etag string) (int32, bool) {
+	etag = strings.TrimSpace(etag)
+	if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) || len(etag) < 2 {
+		return 0, false
+	}
+
+	versionPart := strings.SplitN(strings.Trim(etag, `"`), "-", 2)[0]
+	version, err := strconv.ParseInt(versionPart, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+
+	return int32(version), true
+}
+
+// ifMatchVersion 检查 If-Match 请求头是否和当前版本一致
+// 没有 If-Match 头或者值为 * 时总是满足条件
+func ifMatchVersion(r *http.Request, version int32) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+
+	for _, etag := range strings.Split(header, ",") {
+		etag = strings.TrimSpace(etag)
+		if etag == "*" {
+			return true
+		}
+
+		// If-Match 使用强比较, 弱 ETag 不能匹配
+		if v, ok := etagVersion(etag); ok && v == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ifNoneMatch 检查 If-None-Match 请求头中是否包含 etag, 使用弱比较
+func ifNoneMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeJSONWithETag 与 writeJSON 相同, 同时设置 ETag 响应头
+// etag 为空时根据响应内容生成弱 ETag; 请求的 If-None-Match 匹配时返回 304 且不返回内容
+func (app *application) writeJSONWithETag(w http.ResponseWriter, r *http.Request, status int, data envelope, etag string, headers http.Header) error {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	js = append(js, '\n')
+
+	if etag == "" {
+		sum := sha256.Sum256(js)
+		etag = fmt.Sprintf(`W/"%x"`, sum[:16])
+	}
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	w.Header().Set("ETag", etag)
+
+	if status == http.StatusOK && ifNoneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+
+	return nil
+}
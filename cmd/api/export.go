@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/liliang-cn/greenlight/internal/data"
+	"github.com/liliang-cn/greenlight/internal/validator"
+)
+
+// exportMoviesHandler 以 csv, ndjson 或 json 格式导出电影
+// 支持和列表相同的搜索和排序条件, 不分页
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	// 写入响应的总期限
+	exportTimeout := 10 * time.Minute
+
+	var input struct {
+		data.MovieSearch
+		Format        string
+		RuntimeFormat string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.MovieSearch = app.readMovieSearch(qs, v)
+	input.UserID = app.contextGetUser(r).ID
+	input.Format = app.readString(qs, "format", "csv")
+	input.RuntimeFormat = app.readRuntimeFormat(qs, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = movieSortSafelist
+
+	v.Check(validator.In(input.Format, "csv", "ndjson", "json"), "format", "must be csv, ndjson or json")
+	v.Check(validator.In(input.Filters.Sort, input.Filters.SortSafelist...), "sort", "invalid sort value")
+	v.Check(input.Title != "" || !isRelevanceSort(input.Filters.Sort), "sort", "relevance sort requires a title")
+	data.ValidateMovieSearch(v, input.MovieSearch)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// 搜索条件中的类型别名替换为标准 slug
+	genres, err := app.models.Genres.Registry()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	input.Genres = genres.Canonicalize(input.Genres)
+
+	// 服务器的写超时不足以导出大量电影, 单独延长这个请求的期限
+	err = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportTimeout))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	format := newExportFormat(w, input.Format, input.RuntimeFormat)
+
+	// 写出第一行之前出错时还可以返回错误响应
+	written := false
+
+	start := func() error {
+		if written {
+			return nil
+		}
+		written = true
+
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.`+input.Format+`"`)
+		w.WriteHeader(http.StatusOK)
+
+		return format.begin()
+	}
+
+	err = app.models.Movies.Export(r.Context(), input.MovieSearch, input.Filters, func(movie *data.Movie) error {
+		if err := start(); err != nil {
+			return err
+		}
+		return format.encode(movie)
+	})
+	if err == nil {
+		// 没有匹配的电影时也输出完整的空文件
+		if err = start(); err == nil {
+			err = format.end()
+		}
+	}
+
+	if err != nil {
+		if !written {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		// 响应已经开始发送, 只能记录错误
+		app.logError(r, err)
+	}
+}
+
+// exportFormat 导出文件的编码方式
+type exportFormat struct {
+	contentType string
+	begin       func() error
+	encode      func(*data.Movie) error
+	end         func() error
+}
+
+// newExportFormat 返回对应格式的编码方式, CSV 与导入接口使用相同的表头和编码, 时长总是输出分钟数
+func newExportFormat(w io.Writer, format, runtimeFormat string) exportFormat {
+	switch format {
+	case "ndjson":
+		enc := json.NewEncoder(w)
+
+		return exportFormat{
+			contentType: "application/x-ndjson",
+			begin:       func() error { return nil },
+			encode:      func(movie *data.Movie) error { return enc.Encode(selectFields(movie, nil, runtimeFormat)) },
+			end:         func() error { return nil },
+		}
+
+	case "json":
+		// 和列表接口一样包裹在 movies 字段下, 逐条写出数组元素
+		separator := ""
+
+		return exportFormat{
+			contentType: "application/json",
+			begin: func() error {
+				_, err := io.WriteString(w, "{\"movies\": [")
+				return err
+			},
+			encode: func(movie *data.Movie) error {
+				js, err := json.Marshal(selectFields(movie, nil, runtimeFormat))
+				if err != nil {
+					return err
+				}
+
+				_, err = io.WriteString(w, separator+"\n"+string(js))
+				separator = ","
+				return err
+			},
+			end: func() error {
+				_, err := io.WriteString(w, "\n]}\n")
+				return err
+			},
+		}
+
+	default:
+		cw := csv.NewWriter(w)
+
+		return exportFormat{
+			contentType: "text/csv; charset=utf-8",
+			begin:       func() error { return cw.Write(data.MovieCSVHeader) },
+			encode:      func(movie *data.Movie) error { return cw.Write(movie.MarshalCSV()) },
+			end: func() error {
+				cw.Flush()
+				return cw.Error()
+			},
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+
+	"github.com/liliang-cn/greenlight/internal/data"
+	"github.com/liliang-cn/greenlight/internal/validator"
+)
+
+// movieObject 按指定字段和时长格式输出的电影, fields 为空时按原顺序输出所有字段
+// 先使用电影自身的 JSON 编码再挑选和替换字段, 被 omitempty 省略的字段不会输出
+type movieObject struct {
+	movie         *data.Movie
+	fields        []string
+	runtimeFormat string
+}
+
+func (o movieObject) MarshalJSON() ([]byte, error) {
+	js, err := json.Marshal(o.movie)
+	if err != nil {
+		return nil, err
+	}
+
+	// 逐个读取键值, 保留电影自身的字段顺序
+	var keys []string
+	values := make(map[string]json.RawMessage)
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	if _, err = dec.Token(); err != nil {
+		return nil, err
+	}
+
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return nil, err
+		}
+
+		key := token.(string)
+		keys = append(keys, key)
+		values[key] = value
+	}
+
+	if _, ok := values["runtime"]; ok {
+		values["runtime"], err = json.Marshal(o.movie.Runtime.Format(o.runtimeFormat))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(o.fields) > 0 {
+		keys = o.fields
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	for _, field := range keys {
+		value, ok := values[field]
+		if !ok {
+			continue
+		}
+
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+
+		key, err := json.Marshal(field)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// selectFields 返回只包含 fields 中字段并按 runtimeFormat 输出时长的电影
+// fields 和 runtimeFormat 都为空时直接返回电影
+func selectFields(movie *data.Movie, fields []string, runtimeFormat string) interface{} {
+	if len(fields) == 0 && runtimeFormat == "" {
+		return movie
+	}
+
+	return movieObject{movie: movie, fields: fields, runtimeFormat: runtimeFormat}
+}
+
+// selectFieldsAll 对列表中的每部电影调用 selectFields
+func selectFieldsAll(movies []*data.Movie, fields []string, runtimeFormat string) interface{} {
+	if len(fields) == 0 && runtimeFormat == "" {
+		return movies
+	}
+
+	partial := make([]interface{}, len(movies))
+	for i, movie := range movies {
+		partial[i] = selectFields(movie, fields, runtimeFormat)
+	}
+
+	return partial
+}
+
+// readRuntimeFormat 读取时长的输出格式 runtime_format, 不指定时返回空字符串表示默认格式
+func (app *application) readRuntimeFormat(qs url.Values, v *validator.Validator) string {
+	format := app.readString(qs, "runtime_format", "")
+
+	v.Check(format == "" || validator.In(format, data.RuntimeFormats...), "runtime_format", "must be minutes, human or iso8601")
+
+	return format
+}
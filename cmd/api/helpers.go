@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/liliang-cn/greenlight/internal/validator"
@@ -25,6 +26,17 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// readVersionParam 从 URL 中读取 version
+func (app *application) readVersionParam(r *http.Request) (int32, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	version, err := strconv.ParseInt(params.ByName("version"), 10, 32)
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid version parameter")
+	}
+
+	return int32(version), nil
+}
+
 // 将 json 内容添加一个父级字段, 结构化返回 json
 type envelope map[string]interface{}
 
@@ -138,6 +150,45 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readBool 从 url 的 querystring 中提取布尔值, 没有提供时返回 nil, 如果不能转换，Validator 中提示
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+
+	return &b
+}
+
+// readTime 从 url 的 querystring 中提取时间, 支持 RFC 3339 格式或者日期 (2006-01-02)
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t
+	}
+
+	t, err = time.Parse("2006-01-02", s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp or a date")
+		return time.Time{}
+	}
+
+	return t
+}
+
 // background 通过 goroutine 执行传入函数，若发生 panic 中会恢复
 func (app *application) background(fn func()) {
 	// WaitGroup 计数加1
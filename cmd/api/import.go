@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/liliang-cn/greenlight/internal/data"
+	"github.com/liliang-cn/greenlight/internal/validator"
+)
+
+// importError 导入文件中某一行的错误
+type importError struct {
+	Line   int               `json:"line"`
+	Errors map[string]string `json:"errors"`
+}
+
+// importMoviesHandler 从 NDJSON 或 CSV 批量导入电影
+// mode=atomic (默认) 时任意一行出错则全部回滚, mode=partial 时只跳过出错的行
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	// 导入文件远大于 readJSON 的 1MB 限制, 这里单独限制为 100MB
+	maxBytes := 100 * 1_048_576
+	// 读取请求和写入响应的总期限
+	importTimeout := 10 * time.Minute
+	// 最多返回的错误条数, 避免大文件的错误列表占用过多内存
+	maxErrors := 1000
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+	mode := app.readString(qs, "mode", "atomic")
+	v.Check(validator.In(mode, "atomic", "partial"), "mode", "must be atomic or partial")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// 服务器的读写超时不足以上传和处理这么大的文件, 单独延长这个请求的期限
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(importTimeout)
+
+	err := rc.SetReadDeadline(deadline)
+	if err == nil {
+		err = rc.SetWriteDeadline(deadline)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var dec data.MovieDecoder
+
+	switch mediaType {
+	case "application/x-ndjson":
+		dec = data.NewNDJSONMovieDecoder(r.Body)
+	case "text/csv":
+		dec, err = data.NewCSVMovieDecoder(r.Body)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	default:
+		app.unsupportedMediaTypeResponse(w, r, "application/x-ndjson", "text/csv")
+		return
+	}
+
+	genres, err := app.models.Genres.Registry()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	imp, err := app.models.Movies.BeginImport(r.Context(), user.ID, mode == "partial")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer imp.Rollback()
+
+	imported := 0
+	failed := 0
+	importErrors := []importError{}
+
+	addError := func(line int, errs map[string]string) {
+		failed++
+		if len(importErrors) < maxErrors {
+			importErrors = append(importErrors, importError{Line: line, Errors: errs})
+		}
+	}
+
+	for {
+		movie, err := dec.Decode()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var rowError *data.RowError
+
+			switch {
+			case errors.As(err, &rowError):
+				addError(dec.Line(), map[string]string{rowError.Field: rowError.Message})
+				continue
+			case err.Error() == "http: request body too large":
+				app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytes))
+			default:
+				app.badRequestResponse(w, r, fmt.Errorf("line %d: %w", dec.Line()+1, err))
+			}
+			return
+		}
+
+		v := validator.New()
+		if data.ValidateMovie(v, movie, genres); !v.Valid() {
+			addError(dec.Line(), v.Errors)
+			continue
+		}
+
+		// atomic 模式下已经有错误时不再插入, 只继续校验剩余的行
+		if mode == "atomic" && failed > 0 {
+			continue
+		}
+
+		err = imp.Insert(movie)
+		if err != nil {
+			if mode == "atomic" {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			app.logError(r, err)
+			addError(dec.Line(), map[string]string{"line": "could not be inserted"})
+			continue
+		}
+
+		imported++
+	}
+
+	if mode == "atomic" && failed > 0 {
+		env := envelope{"imported": 0, "failed": failed, "errors": importErrors}
+		err = app.writeJSON(w, http.StatusUnprocessableEntity, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = imp.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"imported": imported, "failed": failed, "errors": importErrors}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
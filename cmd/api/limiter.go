@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// keyedLimiter 按任意字符串 (例如邮箱地址) 分别限流, 超过 idle 没有使用的限流器会被清理
+type keyedLimiter struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	limiters map[string]*keyedLimiterEntry
+}
+
+type keyedLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newKeyedLimiter 返回每个 key 每秒允许 limit 次, 最多突发 burst 次的限流器
+func newKeyedLimiter(limit rate.Limit, burst int, idle time.Duration) *keyedLimiter {
+	l := &keyedLimiter{
+		limit:    limit,
+		burst:    burst,
+		limiters: make(map[string]*keyedLimiterEntry),
+	}
+
+	// 定时移除长时间没有使用的条目
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			l.mu.Lock()
+			for key, entry := range l.limiters {
+				if time.Since(entry.lastSeen) > idle {
+					delete(l.limiters, key)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}()
+
+	return l
+}
+
+// Allow 返回 key 当前是否允许执行
+func (l *keyedLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, found := l.limiters[key]
+	if !found {
+		entry = &keyedLimiterEntry{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[key] = entry
+	}
+
+	entry.lastSeen = time.Now()
+
+	return entry.limiter.Allow()
+}
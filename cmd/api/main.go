@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
@@ -13,10 +14,12 @@ import (
 	"time"
 
 	_ "github.com/lib/pq"
+	"golang.org/x/time/rate"
 
 	"github.com/liliang-cn/greenlight/internal/data"
 	"github.com/liliang-cn/greenlight/internal/jsonlog"
 	"github.com/liliang-cn/greenlight/internal/mailer"
+	"github.com/liliang-cn/greenlight/internal/storage"
 )
 
 var (
@@ -49,15 +52,30 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
+	storage struct {
+		backend  string
+		localDir string
+		localURL string
+		s3       storage.S3Config
+	}
 }
 
 // 应用定义
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	storage storage.Storage
+	// activationLimiter 按邮箱地址限制重新发送激活邮件的频率
+	activationLimiter *keyedLimiter
+	// passwordResetLimiter 按邮箱地址限制发送重置密码邮件的频率
+	passwordResetLimiter *keyedLimiter
+	wg                   sync.WaitGroup
 }
 
 func main() {
@@ -81,6 +99,19 @@ func main() {
 		return nil
 	})
 
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted movies are kept in the trash before being purged")
+	flag.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", time.Hour, "How often to purge expired movies from the trash")
+
+	flag.StringVar(&cfg.storage.backend, "storage-backend", "local", "Storage backend for uploaded files (local|s3)")
+	flag.StringVar(&cfg.storage.localDir, "storage-local-dir", "./uploads", "Directory for uploaded files when using local storage")
+	flag.StringVar(&cfg.storage.localURL, "storage-local-url", "", "Public base URL of uploaded files when using local storage (default http://localhost:<port>/uploads)")
+	flag.StringVar(&cfg.storage.s3.Endpoint, "storage-s3-endpoint", "https://s3.amazonaws.com", "S3-compatible storage endpoint")
+	flag.StringVar(&cfg.storage.s3.Region, "storage-s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&cfg.storage.s3.Bucket, "storage-s3-bucket", "", "S3 bucket")
+	flag.StringVar(&cfg.storage.s3.AccessKey, "storage-s3-access-key", "", "S3 access key")
+	flag.StringVar(&cfg.storage.s3.SecretKey, "storage-s3-secret-key", "", "S3 secret key")
+	flag.StringVar(&cfg.storage.s3.PublicURL, "storage-s3-public-url", "", "Public base URL of uploaded files when using S3 (default <endpoint>/<bucket>)")
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
 	flag.Parse()
@@ -94,6 +125,16 @@ func main() {
 
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	// 清理间隔为 0 或负数时 time.NewTicker 会 panic
+	if cfg.trash.purgeInterval <= 0 {
+		logger.PrintFatal(errors.New("trash-purge-interval must be greater than zero"), nil)
+	}
+
+	// 保留期限为 0 或负数时每次清理都会永久删除回收站中的所有电影和海报
+	if cfg.trash.retention <= 0 {
+		logger.PrintFatal(errors.New("trash-retention must be greater than zero"), nil)
+	}
+
 	// 连接数据库
 	db, err := openDB(cfg)
 	if err != nil {
@@ -105,6 +146,11 @@ func main() {
 
 	logger.PrintInfo("database connection pool established", nil)
 
+	store, err := openStorage(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	// 发布版本信息
 	expvar.NewString("version").Set(version)
 
@@ -125,10 +171,15 @@ func main() {
 
 	// 初始化应用
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:  cfg,
+		logger:  logger,
+		models:  data.NewModels(db),
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage: store,
+		// 每个邮箱每小时最多重新发送 3 次激活邮件
+		activationLimiter: newKeyedLimiter(rate.Every(20*time.Minute), 3, time.Hour),
+		// 每个邮箱每小时最多发送 3 次重置密码邮件
+		passwordResetLimiter: newKeyedLimiter(rate.Every(20*time.Minute), 3, time.Hour),
 	}
 
 	// 启动 server
@@ -163,3 +214,19 @@ func openDB(cfg config) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// 初始化上传文件的存储
+func openStorage(cfg config) (storage.Storage, error) {
+	switch cfg.storage.backend {
+	case "local":
+		baseURL := cfg.storage.localURL
+		if baseURL == "" {
+			baseURL = fmt.Sprintf("http://localhost:%d/uploads", cfg.port)
+		}
+		return storage.NewLocal(cfg.storage.localDir, baseURL)
+	case "s3":
+		return storage.NewS3(cfg.storage.s3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.storage.backend)
+	}
+}
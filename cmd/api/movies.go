@@ -3,12 +3,42 @@ package main
 import (
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/liliang-cn/greenlight/internal/data"
+	"github.com/liliang-cn/greenlight/internal/jsonpatch"
 	"github.com/liliang-cn/greenlight/internal/validator"
 )
 
+// movieSortSafelist 电影列表允许的排序字段
+// relevance 按标题搜索的相关度降序排列, 需要同时提供 title
+var movieSortSafelist = []string{"id", "title", "year", "runtime", "rating", "relevance", "-id", "-title", "-year", "-runtime", "-rating", "-relevance"}
+
+// readMovieSearch 从 querystring 中读取电影列表的搜索条件
+func (app *application) readMovieSearch(qs url.Values, v *validator.Validator) data.MovieSearch {
+	return data.MovieSearch{
+		Title:         app.readString(qs, "title", ""),
+		Genres:        app.readCSV(qs, "genres", []string{}),
+		GenresMode:    app.readString(qs, "genres_mode", "all"),
+		YearMin:       app.readInt(qs, "year_min", 0, v),
+		YearMax:       app.readInt(qs, "year_max", 0, v),
+		RuntimeMin:    app.readInt(qs, "runtime_min", 0, v),
+		RuntimeMax:    app.readInt(qs, "runtime_max", 0, v),
+		CreatedAfter:  app.readTime(qs, "created_after", v),
+		CreatedBefore: app.readTime(qs, "created_before", v),
+		PersonID:      int64(app.readInt(qs, "person_id", 0, v)),
+		OnWatchlist:   app.readBool(qs, "on_watchlist", v),
+	}
+}
+
+// isRelevanceSort 检查是否按相关度排序
+func isRelevanceSort(sort string) bool {
+	return strings.TrimPrefix(sort, "-") == "relevance"
+}
+
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Title   string       `json:"title"`
@@ -25,6 +55,8 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	v := validator.New()
 
+	runtimeFormat := app.readRuntimeFormat(r.URL.Query(), v)
+
 	movie := &data.Movie{
 		Title:   input.Title,
 		Year:    input.Year,
@@ -32,12 +64,20 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Genres:  input.Genres,
 	}
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	genres, err := app.models.Genres.Registry()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	user := app.contextGetUser(r)
+
+	err = app.models.Movies.Insert(movie, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -45,8 +85,9 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", movieETag(movie))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": selectFields(movie, nil, runtimeFormat)}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -59,7 +100,24 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	include := app.readCSV(qs, "include", []string{})
+	for _, relation := range include {
+		v.Check(validator.In(relation, "credits"), "include", "must only contain credits")
+	}
+
+	fields := app.readCSV(qs, "fields", []string{})
+	runtimeFormat := app.readRuntimeFormat(qs, v)
+
+	if data.ValidateMovieFields(v, fields); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.GetFields(id, fields)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -70,7 +128,43 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	etag := movieETag(movie)
+
+	if len(fields) > 0 || runtimeFormat != "" {
+		// 只选择了部分字段时可能没有版本号和评分, 不同的时长格式内容也不同, 根据响应内容生成 ETag
+		etag = ""
+	}
+
+	if len(fields) == 0 || validator.In("title", fields...) {
+		_, err := app.localizeMovies(w, r, []*data.Movie{movie})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// 译名变化不会增加电影的版本号, 即使这次没有使用译名, 之后新增的译名也会改变响应内容
+		// 所以请求了语言时都根据响应内容生成 ETag
+		if r.Header.Get("Accept-Language") != "" {
+			etag = ""
+		}
+	}
+
+	if validator.In("credits", include...) {
+		movie.Credits, err = app.models.Credits.GetForMovie(movie.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		// 演职人员变化不会增加电影的版本号, 根据响应内容生成 ETag
+		etag = ""
+
+		// 嵌入的关联数据总是输出
+		if len(fields) > 0 {
+			fields = append(fields, "credits")
+		}
+	}
+
+	err = app.writeJSONWithETag(w, r, http.StatusOK, envelope{"movie": selectFields(movie, fields, runtimeFormat)}, etag, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -94,44 +188,94 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
-	}
-
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+	// 客户端提供 If-Match 时, 版本不一致直接返回 412
+	if !ifMatchVersion(r, movie.Version) {
+		app.preconditionFailedResponse(w, r)
 		return
 	}
 
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
+	v := validator.New()
 
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
+	runtimeFormat := app.readRuntimeFormat(r.URL.Query(), v)
+
+	// application/json-patch+json 和 application/merge-patch+json 按对应的 RFC 修改,
+	// 其他类型按普通 JSON 处理, 只修改提供的字段
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "application/json-patch+json", "application/merge-patch+json":
+		doc, err := app.patchMovie(w, r, movie, mediaType)
+		if err != nil {
+			var patchErr *jsonpatch.Error
+
+			switch {
+			case errors.Is(err, jsonpatch.ErrTestFailed):
+				app.editConflictResponse(w, r)
+			case errors.As(err, &patchErr):
+				app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+
+		v.Check(doc.ID == movie.ID, "id", "must not be changed")
+		v.Check(doc.Version == movie.Version, "version", "must not be changed")
+
+		movie.Title = doc.Title
+		movie.Year = doc.Year
+		movie.Runtime = doc.Runtime
+		movie.Genres = doc.Genres
+
+	default:
+		var input struct {
+			Title   *string       `json:"title"`
+			Year    *int32        `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+		}
 
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if input.Title != nil {
+			movie.Title = *input.Title
+		}
+
+		if input.Year != nil {
+			movie.Year = *input.Year
+		}
+
+		if input.Runtime != nil {
+			movie.Runtime = *input.Runtime
+		}
+
+		if input.Genres != nil {
+			movie.Genres = input.Genres
+		}
 	}
 
-	if input.Genres != nil {
-		movie.Genres = input.Genres
+	genres, err := app.models.Genres.Registry()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	v := validator.New()
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	user := app.contextGetUser(r)
+
+	err = app.models.Movies.Update(movie, user.ID)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -140,7 +284,10 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": selectFields(movie, nil, runtimeFormat)}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -153,9 +300,34 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	// 没有 If-Match 时不检查版本
+	var version int32
+
+	if r.Header.Get("If-Match") != "" {
+		movie, err := app.models.Movies.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !ifMatchVersion(r, movie.Version) {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+		version = movie.Version
+	}
+
+	err = app.models.Movies.Delete(id, version)
 	if err != nil {
 		switch {
+		// 读取之后电影被修改或删除
+		case errors.Is(err, data.ErrRecordNotFound) && version != 0:
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		default:
@@ -172,34 +344,130 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieSearch
 		data.Filters
+		Fields        []string
+		RuntimeFormat string
 	}
 
 	v := validator.New()
 
 	qs := r.URL.Query()
 
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.MovieSearch = app.readMovieSearch(qs, v)
+	input.UserID = app.contextGetUser(r).ID
+	input.Facets = app.readCSV(qs, "facets", []string{})
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafelist = movieSortSafelist
+	// 带有 cursor 参数 (可以为空) 时使用游标分页
+	input.Filters.UseCursor = qs.Has("cursor")
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Fields = app.readCSV(qs, "fields", []string{})
+	input.RuntimeFormat = app.readRuntimeFormat(qs, v)
+
+	v.Check(input.Title != "" || !isRelevanceSort(input.Filters.Sort), "sort", "relevance sort requires a title")
+
+	data.ValidateMovieSearch(v, input.MovieSearch)
+	data.ValidateMovieFields(v, input.Fields)
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	// 搜索条件中的类型别名替换为标准 slug
+	genres, err := app.models.Genres.Registry()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	input.Genres = genres.Canonicalize(input.Genres)
+
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieSearch, input.Filters, input.Fields)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if len(input.Fields) == 0 || validator.In("title", input.Fields...) {
+		_, err = app.localizeMovies(w, r, movies)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	env := envelope{"movies": selectFieldsAll(movies, input.Fields, input.RuntimeFormat), "metadata": metadata}
+
+	err = app.writeJSONWithETag(w, r, http.StatusOK, env, "", nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listDeletedMoviesHandler 分页获取回收站中的电影
+func (app *application) listDeletedMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafelist = []string{"id", "title", "deleted_at", "-id", "-title", "-deleted_at"}
+	runtimeFormat := app.readRuntimeFormat(qs, v)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetAllDeleted(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": selectFieldsAll(movies, nil, runtimeFormat), "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreMovieHandler 从回收站恢复电影
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	runtimeFormat := app.readRuntimeFormat(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.Restore(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": selectFields(movie, nil, runtimeFormat)}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/liliang-cn/greenlight/internal/data"
+	"github.com/liliang-cn/greenlight/internal/jsonpatch"
+)
+
+// moviePatchDocument JSON Patch 和 JSON Merge Patch 修改的电影文档
+// id 和 version 只读, 可以用于 test 操作
+type moviePatchDocument struct {
+	ID      int64        `json:"id"`
+	Title   string       `json:"title"`
+	Year    int32        `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+	Version int32        `json:"version"`
+}
+
+// patchMovie 根据 Content-Type 使用 JSON Patch 或 JSON Merge Patch 修改电影文档
+func (app *application) patchMovie(w http.ResponseWriter, r *http.Request, movie *data.Movie, mediaType string) (*moviePatchDocument, error) {
+	doc := moviePatchDocument{
+		ID:      movie.ID,
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+		Version: movie.Version,
+	}
+
+	js, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var target interface{}
+	err = json.Unmarshal(js, &target)
+	if err != nil {
+		return nil, err
+	}
+
+	var patched interface{}
+
+	switch mediaType {
+	case "application/json-patch+json":
+		var patch []jsonpatch.Operation
+
+		err = app.readJSON(w, r, &patch)
+		if err != nil {
+			return nil, err
+		}
+
+		patched, err = jsonpatch.Apply(target, patch)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		var patch interface{}
+
+		err = app.readJSON(w, r, &patch)
+		if err != nil {
+			return nil, err
+		}
+
+		patched = jsonpatch.MergePatch(target, patch)
+	}
+
+	return decodeMoviePatchDocument(patched)
+}
+
+// decodeMoviePatchDocument 将修改后的文档转换回电影字段, 不允许添加未知字段
+func decodeMoviePatchDocument(patched interface{}) (*moviePatchDocument, error) {
+	js, err := json.Marshal(patched)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+
+	var doc moviePatchDocument
+
+	err = dec.Decode(&doc)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+
+		switch {
+		case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+			return nil, fmt.Errorf("patched document contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+		case errors.As(err, &unmarshalTypeError):
+			return nil, errors.New("patched document must be a JSON object")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return nil, fmt.Errorf("patched document contains unknown key %s", fieldName)
+		default:
+			return nil, fmt.Errorf("patched document is invalid: %w", err)
+		}
+	}
+
+	return &doc, nil
+}
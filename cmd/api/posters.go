@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/liliang-cn/greenlight/internal/data"
+	"github.com/liliang-cn/greenlight/internal/imaging"
+	"github.com/liliang-cn/greenlight/internal/validator"
+)
+
+// maxPosterBytes 上传海报的最大字节数
+const maxPosterBytes = 10 * 1_048_576
+
+// posterSizes 生成的缩略图尺寸 (宽度), 原图保存为 original
+var posterSizes = []struct {
+	name  string
+	width int
+}{
+	{"small", 185},
+	{"medium", 342},
+	{"large", 780},
+}
+
+// posterContentTypes 允许上传的图片类型
+var posterContentTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// posterObjectKeys 返回海报前缀下所有文件的 key
+func posterObjectKeys(posterKey string) []string {
+	keys := []string{posterKey + "/original"}
+	for _, size := range posterSizes {
+		keys = append(keys, posterKey+"/"+size.name+".jpg")
+	}
+
+	return keys
+}
+
+// readPoster 读取上传的海报, 支持 multipart/form-data 的 poster 字段或者直接上传图片
+// 超过 maxPosterBytes 时返回 errPosterTooLarge
+func (app *application) readPoster(r *http.Request) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	body := io.Reader(r.Body)
+
+	if mediaType == "multipart/form-data" {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			part, err := mr.NextPart()
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New("multipart body must contain a poster field")
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			if part.FormName() == "poster" {
+				body = part
+				break
+			}
+		}
+	}
+
+	poster, err := io.ReadAll(io.LimitReader(body, maxPosterBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(poster) > maxPosterBytes {
+		return nil, errPosterTooLarge
+	}
+
+	if len(poster) == 0 {
+		return nil, errors.New("poster must not be empty")
+	}
+
+	return poster, nil
+}
+
+var errPosterTooLarge = fmt.Errorf("poster must not be larger than %d bytes", maxPosterBytes)
+
+// storePoster 生成缩略图并保存海报的所有文件, 返回海报前缀和各个尺寸的地址
+// 保存失败时删除已经保存的文件
+func (app *application) storePoster(ctx context.Context, movieID int64, poster []byte, contentType string) (string, data.PosterURLs, error) {
+	img, _, err := imaging.Decode(poster)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// 每次上传使用新的前缀, 替换海报后旧地址的缓存不会影响新海报
+	token := make([]byte, 8)
+	_, err = rand.Read(token)
+	if err != nil {
+		return "", nil, err
+	}
+	posterKey := fmt.Sprintf("posters/%d/%s", movieID, hex.EncodeToString(token))
+
+	// objects 的顺序与 posterObjectKeys 相同
+	keys := posterObjectKeys(posterKey)
+	objects := [][]byte{poster}
+	names := []string{"original"}
+
+	// 只转换一次完整尺寸的图片, 各个尺寸都从它缩小
+	src := imaging.Flatten(img)
+
+	for _, size := range posterSizes {
+		thumbnail, err := imaging.EncodeJPEG(imaging.Resize(src, size.width), 85)
+		if err != nil {
+			return "", nil, err
+		}
+		objects = append(objects, thumbnail)
+		names = append(names, size.name)
+	}
+
+	urls := data.PosterURLs{}
+
+	for i, obj := range objects {
+		objectType := "image/jpeg"
+		if i == 0 {
+			objectType = contentType
+		}
+
+		err = app.storage.Put(ctx, keys[i], obj, objectType)
+		if err != nil {
+			app.deletePoster(posterKey)
+			return "", nil, err
+		}
+
+		urls[names[i]] = app.storage.URL(keys[i])
+	}
+
+	return posterKey, urls, nil
+}
+
+// deletePoster 删除海报的所有文件, 错误只记录日志
+func (app *application) deletePoster(posterKey string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, key := range posterObjectKeys(posterKey) {
+		err := app.storage.Delete(ctx, key)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"key": key})
+		}
+	}
+}
+
+// uploadPosterHandler 上传电影海报并生成缩略图, 替换已有的海报
+func (app *application) uploadPosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !ifMatchVersion(r, movie.Version) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	runtimeFormat := app.readRuntimeFormat(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// multipart 的边界和字段头需要额外的空间
+	r.Body = http.MaxBytesReader(w, r.Body, maxPosterBytes+1_048_576)
+
+	poster, err := app.readPoster(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPosterTooLarge), err.Error() == "http: request body too large":
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, errPosterTooLarge.Error())
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	// 根据内容判断图片类型, 不信任客户端提供的 Content-Type
+	contentType := http.DetectContentType(poster)
+	if !validator.In(contentType, posterContentTypes...) {
+		app.unsupportedMediaTypeResponse(w, r, posterContentTypes...)
+		return
+	}
+
+	posterKey, urls, err := app.storePoster(r.Context(), movie.ID, poster, contentType)
+	if err != nil {
+		switch {
+		case errors.Is(err, imaging.ErrTooLarge):
+			v.AddError("poster", fmt.Sprintf("must not be wider or taller than %d pixels or have more than %d pixels in total", imaging.MaxDimension, imaging.MaxPixels))
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, imaging.ErrUnsupportedFormat):
+			app.unsupportedMediaTypeResponse(w, r, posterContentTypes...)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	oldPosterKey := movie.PosterKey
+	user := app.contextGetUser(r)
+
+	err = app.models.Movies.SetPoster(movie, posterKey, urls, user.ID)
+	if err != nil {
+		app.background(func() { app.deletePoster(posterKey) })
+
+		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if oldPosterKey != "" {
+		app.background(func() { app.deletePoster(oldPosterKey) })
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": selectFields(movie, nil, runtimeFormat)}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deletePosterHandler 删除电影海报
+func (app *application) deletePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if movie.PosterKey == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !ifMatchVersion(r, movie.Version) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	oldPosterKey := movie.PosterKey
+	user := app.contextGetUser(r)
+
+	err = app.models.Movies.SetPoster(movie, "", nil, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.background(func() { app.deletePoster(oldPosterKey) })
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "poster successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
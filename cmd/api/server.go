@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 )
@@ -23,6 +24,9 @@ func (app *application) serve() error {
 	// 使用 shutDownError 通道来接收 Shutdown() 函数返回的错误
 	shutdownError := make(chan error)
 
+	// 关闭 stopPurge 通知清理回收站的 goroutine 退出
+	stopPurge := make(chan struct{})
+
 	go func() {
 		// 新建 channel 用来携带系统信号
 		quit := make(chan os.Signal, 1)
@@ -51,10 +55,18 @@ func (app *application) serve() error {
 		})
 
 		// 等待所有 goroutine 结束
+		close(stopPurge)
 		app.wg.Wait()
 		shutdownError <- nil
 	}()
 
+	// 定时清理回收站
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		app.purgeDeletedMovies(stopPurge)
+	}()
+
 	app.logger.PrintInfo("starting server", map[string]string{
 		"addr": srv.Addr,
 		"env":  app.config.env,
@@ -76,3 +88,34 @@ func (app *application) serve() error {
 
 	return nil
 }
+
+// purgeDeletedMovies 定时永久删除回收站中超过保留期限的电影, stop 关闭后退出
+func (app *application) purgeDeletedMovies(stop <-chan struct{}) {
+	ticker := time.NewTicker(app.config.trash.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		n, posterKeys, err := app.models.Movies.PurgeDeleted(app.config.trash.retention)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+
+		// 电影在回收站中时还可以恢复, 永久删除后才清理海报文件
+		for _, posterKey := range posterKeys {
+			app.deletePoster(posterKey)
+		}
+
+		if n > 0 {
+			app.logger.PrintInfo("purged deleted movies", map[string]string{
+				"count": strconv.FormatInt(n, 10),
+			})
+		}
+	}
+}
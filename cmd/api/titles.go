@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/liliang-cn/greenlight/internal/data"
+	"github.com/liliang-cn/greenlight/internal/validator"
+)
+
+// parseAcceptLanguage 解析 Accept-Language, 按权重从高到低返回语言, 权重相同时保持原顺序
+// 权重为 0 或者格式错误的语言会被忽略
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		language string
+		q        float64
+	}
+
+	var ranges []weighted
+
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+
+		language := strings.TrimSpace(params[0])
+		if language != "*" && !data.LanguageTagRX.MatchString(language) {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+
+			value, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+			if err != nil || value < 0 || value > 1 {
+				value = 0
+			}
+			q = value
+		}
+
+		if q > 0 {
+			ranges = append(ranges, weighted{language: language, q: q})
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	languages := make([]string, len(ranges))
+	for i, r := range ranges {
+		languages[i] = r.language
+	}
+
+	return languages
+}
+
+// localizeMovies 根据 Accept-Language 将电影标题替换为最合适的译名, 并设置 Vary 和 Content-Language 响应头
+// 返回 Content-Language 的值, 没有使用任何译名时为空字符串
+func (app *application) localizeMovies(w http.ResponseWriter, r *http.Request, movies []*data.Movie) (string, error) {
+	w.Header().Add("Vary", "Accept-Language")
+
+	languages := parseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if len(languages) == 0 {
+		return "", nil
+	}
+
+	used, err := app.models.Titles.Localize(movies, languages)
+	if err != nil {
+		return "", err
+	}
+
+	var contentLanguage []string
+	for _, language := range used {
+		if language != "" && !validator.In(language, contentLanguage...) {
+			contentLanguage = append(contentLanguage, language)
+		}
+	}
+
+	if len(contentLanguage) == 0 {
+		return "", nil
+	}
+
+	value := strings.Join(contentLanguage, ", ")
+	w.Header().Set("Content-Language", value)
+
+	return value, nil
+}
+
+// listMovieTitlesHandler 获取电影的所有译名
+func (app *application) listMovieTitlesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	titles, err := app.models.Titles.GetForMovie(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"titles": titles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// putMovieTitleHandler 新增或替换电影在 URL 中语言的译名
+func (app *application) putMovieTitleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Title string `json:"title"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	title := &data.MovieTitle{
+		MovieID:  id,
+		Language: httprouter.ParamsFromContext(r.Context()).ByName("language"),
+		Title:    input.Title,
+	}
+
+	v := validator.New()
+	if data.ValidateMovieTitle(v, title); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	title.Language = data.CanonicalLanguageTag(title.Language)
+
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	created, err := app.models.Titles.Upsert(title)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	err = app.writeJSON(w, status, envelope{"title": title}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteMovieTitleHandler 删除电影在 URL 中语言的译名
+func (app *application) deleteMovieTitleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	language := httprouter.ParamsFromContext(r.Context()).ByName("language")
+
+	err = app.models.Titles.Delete(id, data.CanonicalLanguageTag(language))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "title successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/liliang-cn/greenlight
 
-go 1.17
+go 1.20
 
 require (
 	github.com/julienschmidt/httprouter v1.3.0
@@ -0,0 +1,201 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/liliang-cn/greenlight/internal/validator"
+)
+
+// MovieChanges 批量修改电影的内容, 为空的字段不修改
+// Genres 替换所有类型, AddGenres 和 RemoveGenres 在原有类型上追加或删除
+type MovieChanges struct {
+	Year         *int32
+	Runtime      *Runtime
+	Genres       []string
+	AddGenres    []string
+	RemoveGenres []string
+}
+
+// ValidateMovieChanges 校验批量修改的内容, 修改后的电影还需要单独使用 ValidateMovie 校验
+func ValidateMovieChanges(v *validator.Validator, changes MovieChanges) {
+	v.Check(changes.Year != nil || changes.Runtime != nil || changes.Genres != nil || len(changes.AddGenres) > 0 || len(changes.RemoveGenres) > 0, "changes", "must not be empty")
+	v.Check(changes.Genres == nil || (len(changes.AddGenres) == 0 && len(changes.RemoveGenres) == 0), "genres", "must not be combined with add_genres or remove_genres")
+}
+
+// Apply 将修改应用到电影上
+func (c MovieChanges) Apply(movie *Movie) {
+	if c.Year != nil {
+		movie.Year = *c.Year
+	}
+
+	if c.Runtime != nil {
+		movie.Runtime = *c.Runtime
+	}
+
+	if c.Genres != nil {
+		movie.Genres = append([]string{}, c.Genres...)
+	}
+
+	genres := []string{}
+	for _, genre := range movie.Genres {
+		if !validator.In(genre, c.RemoveGenres...) {
+			genres = append(genres, genre)
+		}
+	}
+
+	for _, genre := range c.AddGenres {
+		if !validator.In(genre, genres...) {
+			genres = append(genres, genre)
+		}
+	}
+
+	movie.Genres = genres
+}
+
+// Empty 检查搜索条件是否为空, 为空时会匹配所有电影
+func (s MovieSearch) Empty() bool {
+	return s.Title == "" && len(s.Genres) == 0 &&
+		s.YearMin == 0 && s.YearMax == 0 && s.RuntimeMin == 0 && s.RuntimeMax == 0 &&
+		s.CreatedAfter.IsZero() && s.CreatedBefore.IsZero() &&
+		s.PersonID == 0 && s.OnWatchlist == nil
+}
+
+// MovieBulk 在同一个事务中批量修改或删除电影
+type MovieBulk struct {
+	ctx    context.Context
+	tx     *sql.Tx
+	userID int64
+}
+
+// BeginBulk 开始一次批量操作, 调用方必须调用 Commit 或 Rollback
+func (m MovieModel) BeginBulk(ctx context.Context, userID int64) (*MovieBulk, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MovieBulk{ctx: ctx, tx: tx, userID: userID}, nil
+}
+
+// Select 锁定并返回要操作的电影, ids 不为空时按 ids 选择, 否则使用搜索条件
+// 最多返回 limit 部电影, 调用方可以多查询一部来判断是否超过上限
+func (b *MovieBulk) Select(ids []int64, search MovieSearch, limit int) ([]*Movie, error) {
+	q := newMovieQuery(search)
+	if len(ids) > 0 {
+		q = newMovieQuery(MovieSearch{})
+		q.where(fmt.Sprintf("movies.id = ANY(%s)", q.arg(pq.Array(ids))))
+	}
+
+	query := fmt.Sprintf(`
+	SELECT %s
+	%s
+	ORDER BY movies.id ASC
+	LIMIT %s
+	FOR UPDATE OF movies`, movieListColumns, q.from(), q.arg(limit))
+
+	rows, err := b.tx.QueryContext(b.ctx, query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(scanMovieListColumns(&movie)...)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// Update 在批量事务中更新电影并记录新的版本, 电影已经被 Select 锁定
+func (b *MovieBulk) Update(movie *Movie) error {
+	query := `
+        UPDATE movies
+        SET year = $1, runtime = $2, genres = $3, version = version + 1
+        WHERE id = $4
+        RETURNING version`
+
+	args := []interface{}{movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.ID}
+
+	err := b.tx.QueryRowContext(b.ctx, query, args...).Scan(&movie.Version)
+	if err != nil {
+		return err
+	}
+
+	return insertMovieRevision(b.ctx, b.tx, movie, b.userID)
+}
+
+// Delete 在批量事务中将电影移入回收站, 增加版本号并记录修订, 新的版本号写回 movies
+func (b *MovieBulk) Delete(movies []*Movie) error {
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	query := `
+        UPDATE movies
+        SET deleted_at = NOW(), version = version + 1
+        WHERE id = ANY($1) AND deleted_at IS NULL
+        RETURNING id, version`
+
+	rows, err := b.tx.QueryContext(b.ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	versions := make(map[int64]int32, len(movies))
+	for rows.Next() {
+		var id int64
+		var version int32
+		if err := rows.Scan(&id, &version); err != nil {
+			rows.Close()
+			return err
+		}
+		versions[id] = version
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		version, ok := versions[movie.ID]
+		if !ok {
+			continue
+		}
+		movie.Version = version
+
+		err = insertMovieRevision(b.ctx, b.tx, movie, b.userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Commit 提交批量事务
+func (b *MovieBulk) Commit() error {
+	return b.tx.Commit()
+}
+
+// Rollback 回滚批量事务
+func (b *MovieBulk) Rollback() error {
+	return b.tx.Rollback()
+}
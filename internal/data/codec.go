@@ -0,0 +1,220 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/liliang-cn/greenlight/internal/validator"
+)
+
+// MovieCSVHeader 导入导出 CSV 时使用的表头
+var MovieCSVHeader = []string{"title", "year", "runtime", "genres"}
+
+// genresCSVSeparator CSV 中多个类型之间的分隔符
+const genresCSVSeparator = "|"
+
+// RowError 导入时某一行无法解析, 可以跳过该行继续读取
+type RowError struct {
+	Field   string
+	Message string
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// MovieDecoder 从导入文件中逐行读取电影
+type MovieDecoder interface {
+	// Decode 返回下一部电影, 读取完毕时返回 io.EOF
+	// 返回 *RowError 时表示当前行无效，可以继续调用 Decode
+	Decode() (*Movie, error)
+	// Line 返回最近一次 Decode 的行号
+	Line() int
+}
+
+// MarshalCSV 将电影转换为一行 CSV, 列顺序与 MovieCSVHeader 一致
+// runtime 为分钟数, genres 用 "|" 分隔
+func (movie *Movie) MarshalCSV() []string {
+	return []string{
+		movie.Title,
+		strconv.FormatInt(int64(movie.Year), 10),
+		strconv.FormatInt(int64(movie.Runtime), 10),
+		strings.Join(movie.Genres, genresCSVSeparator),
+	}
+}
+
+type csvMovieDecoder struct {
+	reader  *csv.Reader
+	columns map[string]int
+	line    int
+}
+
+// NewCSVMovieDecoder 返回 CSV 解码器, 第一行必须是表头
+func NewCSVMovieDecoder(r io.Reader) (MovieDecoder, error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("body must not be empty")
+		}
+		return nil, fmt.Errorf("body contains an invalid CSV header: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("CSV header contains duplicate column %q", name)
+		}
+		columns[name] = i
+	}
+
+	for name := range columns {
+		if !validator.In(name, MovieCSVHeader...) {
+			return nil, fmt.Errorf("CSV header contains unknown column %q", name)
+		}
+	}
+
+	return &csvMovieDecoder{reader: reader, columns: columns, line: 1}, nil
+}
+
+func (d *csvMovieDecoder) Line() int {
+	return d.line
+}
+
+func (d *csvMovieDecoder) Decode() (*Movie, error) {
+	record, err := d.reader.Read()
+	if err != nil {
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			d.line = parseError.StartLine
+			return nil, &RowError{Field: "line", Message: parseError.Err.Error()}
+		}
+		return nil, err
+	}
+
+	d.line, _ = d.reader.FieldPos(0)
+
+	field := func(name string) string {
+		i, ok := d.columns[name]
+		if !ok {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	movie := &Movie{Title: field("title")}
+
+	if s := field("year"); s != "" {
+		year, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return nil, &RowError{Field: "year", Message: "must be an integer value"}
+		}
+		movie.Year = int32(year)
+	}
+
+	if s := field("runtime"); s != "" {
+		runtime, err := ParseRuntime(s)
+		if err != nil {
+			return nil, &RowError{Field: "runtime", Message: err.Error()}
+		}
+		movie.Runtime = runtime
+	}
+
+	if s := field("genres"); s != "" {
+		movie.Genres = []string{}
+		for _, genre := range strings.Split(s, genresCSVSeparator) {
+			if genre = strings.TrimSpace(genre); genre != "" {
+				movie.Genres = append(movie.Genres, genre)
+			}
+		}
+	}
+
+	return movie, nil
+}
+
+type ndjsonMovieDecoder struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewNDJSONMovieDecoder 返回 NDJSON 解码器, 每行一个 JSON 对象, 单行最大 1MB
+func NewNDJSONMovieDecoder(r io.Reader) MovieDecoder {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1_048_576)
+
+	return &ndjsonMovieDecoder{scanner: scanner}
+}
+
+func (d *ndjsonMovieDecoder) Line() int {
+	return d.line
+}
+
+func (d *ndjsonMovieDecoder) Decode() (*Movie, error) {
+	var line []byte
+
+	// 跳过空行
+	for len(line) == 0 {
+		if !d.scanner.Scan() {
+			if err := d.scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		d.line++
+		line = bytes.TrimSpace(d.scanner.Bytes())
+	}
+
+	var input struct {
+		Title   string   `json:"title"`
+		Year    int32    `json:"year"`
+		Runtime Runtime  `json:"runtime"`
+		Genres  []string `json:"genres"`
+		// 导出文件中的只读字段, 导入时忽略
+		ID            int64      `json:"id"`
+		AverageRating float64    `json:"average_rating"`
+		RatingCount   int32      `json:"rating_count"`
+		Version       int32      `json:"version"`
+		PosterURLs    PosterURLs `json:"poster_urls"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&input)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+
+		switch {
+		case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+			return nil, &RowError{Field: unmarshalTypeError.Field, Message: "incorrect JSON type"}
+		case errors.Is(err, ErrInvalidRuntimeFormat):
+			return nil, &RowError{Field: "runtime", Message: err.Error()}
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return nil, &RowError{Field: "line", Message: "contains unknown key " + strings.TrimPrefix(err.Error(), "json: unknown field ")}
+		default:
+			return nil, &RowError{Field: "line", Message: "contains badly-formed JSON"}
+		}
+	}
+
+	if dec.More() {
+		return nil, &RowError{Field: "line", Message: "must only contain a single JSON value"}
+	}
+
+	return &Movie{
+		Title:   input.Title,
+		Year:    input.Year,
+		Runtime: input.Runtime,
+		Genres:  input.Genres,
+	}, nil
+}
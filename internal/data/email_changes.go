@@ -0,0 +1,73 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// EmailChangeModel 等待确认的邮箱修改, 每个用户最多一条, 新邮箱确认后才会修改用户的邮箱
+type EmailChangeModel struct {
+	DB *sql.DB
+}
+
+// Insert 记录用户要修改的新邮箱, 替换之前未确认的修改
+func (m EmailChangeModel) Insert(userID int64, email string) error {
+	query := `
+		INSERT INTO email_changes (user_id, email)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET email = EXCLUDED.email, created_at = NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, email)
+	return err
+}
+
+// Confirm 将用户的邮箱修改为等待确认的新邮箱, 使用 version 做乐观锁
+func (m EmailChangeModel) Confirm(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var email string
+
+	err = tx.QueryRowContext(ctx, `DELETE FROM email_changes WHERE user_id = $1 RETURNING email`, user.ID).Scan(&email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query := `
+		UPDATE users
+		SET email = $1, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, email, user.ID, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	user.Email = email
+
+	return tx.Commit()
+}
@@ -0,0 +1,113 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/liliang-cn/greenlight/internal/validator"
+)
+
+// MovieFieldSafelist 可以通过 fields 参数选择的电影字段, relevance 和 highlight 只在按标题搜索时有值
+var MovieFieldSafelist = []string{"id", "title", "year", "runtime", "genres", "average_rating", "rating_count", "version", "poster_urls", "relevance", "highlight"}
+
+// ValidateMovieFields 校验需要返回的字段
+func ValidateMovieFields(v *validator.Validator, fields []string) {
+	for _, field := range fields {
+		v.Check(validator.In(field, MovieFieldSafelist...), "fields", fmt.Sprintf("unknown field %q", field))
+	}
+	v.Check(validator.Unique(fields), "fields", "must not contain duplicate values")
+}
+
+// movieColumn 电影字段对应的列和扫描目标
+type movieColumn struct {
+	field  string
+	column string
+	dest   func(*Movie) interface{}
+}
+
+// movieColumns 电影表中可以选择的列, 顺序与 movieListColumns 相同
+var movieColumns = []movieColumn{
+	{"id", "movies.id", func(m *Movie) interface{} { return &m.ID }},
+	{"created_at", "movies.created_at", func(m *Movie) interface{} { return &m.CreatedAt }},
+	{"title", "movies.title", func(m *Movie) interface{} { return &m.Title }},
+	{"year", "movies.year", func(m *Movie) interface{} { return &m.Year }},
+	{"runtime", "movies.runtime", func(m *Movie) interface{} { return &m.Runtime }},
+	{"genres", "movies.genres", func(m *Movie) interface{} { return pq.Array(&m.Genres) }},
+	{"average_rating", "movies.average_rating", func(m *Movie) interface{} { return &m.AverageRating }},
+	{"rating_count", "movies.rating_count", func(m *Movie) interface{} { return &m.RatingCount }},
+	{"version", "movies.version", func(m *Movie) interface{} { return &m.Version }},
+	{"poster_urls", "movies.poster_urls", func(m *Movie) interface{} { return &m.PosterURLs }},
+}
+
+// movieSelection 需要从电影表中选择的列
+type movieSelection []movieColumn
+
+// selectMovieColumns 返回 fields 对应的列, fields 为空时选择所有列
+// required 中的字段 (例如排序和游标需要的列) 即使没有请求也会被选择
+func selectMovieColumns(fields []string, required ...string) movieSelection {
+	var selection movieSelection
+
+	for _, c := range movieColumns {
+		if len(fields) == 0 || validator.In(c.field, fields...) || validator.In(c.field, required...) {
+			selection = append(selection, c)
+		}
+	}
+
+	return selection
+}
+
+// columns 返回 SELECT 中的列
+func (s movieSelection) columns() string {
+	columns := make([]string, len(s))
+	for i, c := range s {
+		columns[i] = c.column
+	}
+
+	return strings.Join(columns, ", ")
+}
+
+// dest 返回扫描到 movie 的目标
+func (s movieSelection) dest(movie *Movie) []interface{} {
+	dest := make([]interface{}, len(s))
+	for i, c := range s {
+		dest[i] = c.dest(movie)
+	}
+
+	return dest
+}
+
+// GetFields 与 Get 相同, 但只选择 fields 中的列, fields 为空时选择所有列
+func (m MovieModel) GetFields(id int64, fields []string) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	selection := selectMovieColumns(fields, "id")
+
+	query := fmt.Sprintf(`
+        SELECT %s
+        FROM movies
+        WHERE id = $1 AND deleted_at IS NULL`, selection.columns())
+
+	var movie Movie
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(selection.dest(&movie)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
@@ -1,17 +1,28 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"strings"
 
 	"github.com/liliang-cn/greenlight/internal/validator"
 )
 
+// ErrInvalidCursor 游标无法解析或与当前排序不匹配
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	// UseCursor 为 true 时使用游标分页，忽略 Page
+	UseCursor bool
+	// Cursor 上一页返回的 next_cursor, 为空表示第一页
+	Cursor string
 }
 
 // ValidateFilters 校验过滤条件
@@ -21,22 +32,46 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	if f.UseCursor && f.Cursor != "" {
+		_, err := f.decodeCursor()
+		v.Check(err == nil, "cursor", "invalid cursor value")
+	}
+}
+
+// sortColumnAliases 排序参数与数据库列名不一致时的映射
+var sortColumnAliases = map[string]string{
+	"rating": "average_rating",
 }
 
 // sortColumn 检查客户端提供的排序条件是否在允许排序的列表中
 func (f Filters) sortColumn() string {
 	for _, safeValue := range f.SortSafelist {
 		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+			column := strings.TrimPrefix(f.Sort, "-")
+			if alias, ok := sortColumnAliases[column]; ok {
+				return alias
+			}
+			return column
 		}
 	}
 
 	panic("unsafe sort parameter: " + f.Sort)
 }
 
+// descendingSorts 默认按降序排列的排序参数, 例如相关度越高越靠前, 加 "-" 前缀时反过来按升序
+var descendingSorts = map[string]bool{
+	"relevance": true,
+}
+
 // sortDirection 返回排序正向或者反向
 func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
+	descending := strings.HasPrefix(f.Sort, "-")
+	if descendingSorts[strings.TrimPrefix(f.Sort, "-")] {
+		descending = !descending
+	}
+
+	if descending {
 		return "DESC"
 	}
 
@@ -53,13 +88,69 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// cursor 游标内容，记录上一页最后一行的排序值和 id
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"i"`
+}
+
+// encodeCursor 将游标编码为不透明的字符串
+func encodeCursor(c cursor) string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// decodeCursor 解析客户端传入的游标, 游标必须是在当前排序下生成的
+func (f Filters) decodeCursor() (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(f.Cursor)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.Sort != f.Sort || c.ID < 1 {
+		return c, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// cursorCondition 返回游标分页的 WHERE 条件, arg 添加参数并返回占位符
+// 排序列相同时用 id 升序作为次级排序
+func (f Filters) cursorCondition(arg func(interface{}) string) (string, error) {
+	c, err := f.decodeCursor()
+	if err != nil {
+		return "", err
+	}
+
+	op := ">"
+	if f.sortDirection() == "DESC" {
+		op = "<"
+	}
+
+	column := f.sortColumn()
+	if column == "id" {
+		return fmt.Sprintf("movies.id %s %s", op, arg(c.ID)), nil
+	}
+
+	value := arg(c.Value)
+	condition := fmt.Sprintf("(%[1]s %[2]s %[3]s OR (%[1]s = %[3]s AND movies.id > %[4]s))", column, op, value, arg(c.ID))
+	return condition, nil
+}
+
 // Metadata 分页信息
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	// Facets 整个搜索结果的分面统计
+	Facets map[string][]FacetCount `json:"facets,omitempty"`
 }
 
 // calculateMetadata 返回分分页信息
@@ -76,3 +167,13 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 		TotalRecords: totalRecords,
 	}
 }
+
+// calculateCursorMetadata 返回游标分页信息, 没有下一页时 next_cursor 为空
+func calculateCursorMetadata(pageSize int, next *cursor) Metadata {
+	metadata := Metadata{PageSize: pageSize}
+	if next != nil {
+		metadata.NextCursor = encodeCursor(*next)
+	}
+
+	return metadata
+}
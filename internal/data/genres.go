@@ -0,0 +1,371 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+	"github.com/liliang-cn/greenlight/internal/validator"
+)
+
+var (
+	ErrDuplicateGenre = errors.New("duplicate genre")
+	ErrGenreInUse     = errors.New("genre in use")
+)
+
+// GenreSlugRX 类型 slug 只能包含小写字母, 数字和 -
+var GenreSlugRX = regexp.MustCompile("^[a-z0-9]+(-[a-z0-9]+)*$")
+
+// Genre 类型登记表中的类型, 电影的 genres 字段保存 Slug
+type Genre struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	Aliases   []string  `json:"aliases"`
+	Version   int32     `json:"version"`
+}
+
+// ValidateGenre 校验类型, slug, 名称和别名不能与登记表中的其他类型冲突
+func ValidateGenre(v *validator.Validator, genre *Genre, genres *GenreRegistry) {
+	v.Check(genre.Slug != "", "slug", "must be provided")
+	v.Check(len(genre.Slug) <= 100, "slug", "must not be more than 100 bytes long")
+	v.Check(validator.Matches(genre.Slug, GenreSlugRX), "slug", "must only contain lowercase letters, digits and hyphens")
+
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(genre.Name == "" || normalizeGenre(genre.Name) != "", "name", "must contain at least one letter or digit")
+
+	v.Check(genre.Aliases != nil, "aliases", "must be provided")
+	v.Check(len(genre.Aliases) <= 20, "aliases", "must not contain more than 20 aliases")
+	v.Check(validator.Unique(genre.Aliases), "aliases", "must not contain duplicate values")
+	for _, alias := range genre.Aliases {
+		v.Check(normalizeGenre(alias) != "", "aliases", "must only contain values with at least one letter or digit")
+		v.Check(len(alias) <= 100, "aliases", "must not contain values more than 100 bytes long")
+	}
+
+	if genres == nil {
+		return
+	}
+
+	if other := genres.lookup(genre.Slug); other != nil && other.ID != genre.ID {
+		v.AddError("slug", fmt.Sprintf("is already used by genre %q", other.Slug))
+	}
+
+	if other := genres.lookup(genre.Name); other != nil && other.ID != genre.ID {
+		v.AddError("name", fmt.Sprintf("is already used by genre %q", other.Slug))
+	}
+
+	for _, alias := range genre.Aliases {
+		if other := genres.lookup(alias); other != nil && other.ID != genre.ID {
+			v.AddError("aliases", fmt.Sprintf("%q is already used by genre %q", alias, other.Slug))
+		}
+	}
+}
+
+// normalizeGenre 类型名称比较时使用的形式: 小写, 连续的非字母数字字符替换为 -
+// 字母和数字包括非 ASCII 字符, 例如 "Ciencia Ficción" 转换为 "ciencia-ficción"
+func normalizeGenre(name string) string {
+	var b strings.Builder
+	separator := false
+
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if separator && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			separator = false
+			b.WriteRune(r)
+			continue
+		}
+		separator = true
+	}
+
+	return b.String()
+}
+
+// GenreRegistry 类型登记表, 按 slug, 名称和别名查找标准类型
+type GenreRegistry struct {
+	genres map[string]*Genre
+}
+
+// NewGenreRegistry 根据类型列表构造登记表, slug 优先于名称和别名
+func NewGenreRegistry(genres []*Genre) *GenreRegistry {
+	r := &GenreRegistry{genres: make(map[string]*Genre, len(genres)*2)}
+
+	for _, genre := range genres {
+		r.add(genre.Name, genre)
+		for _, alias := range genre.Aliases {
+			r.add(alias, genre)
+		}
+	}
+
+	for _, genre := range genres {
+		r.genres[normalizeGenre(genre.Slug)] = genre
+	}
+
+	return r
+}
+
+func (r *GenreRegistry) add(name string, genre *Genre) {
+	key := normalizeGenre(name)
+	if key == "" {
+		return
+	}
+	if _, exists := r.genres[key]; !exists {
+		r.genres[key] = genre
+	}
+}
+
+func (r *GenreRegistry) lookup(name string) *Genre {
+	return r.genres[normalizeGenre(name)]
+}
+
+// Canonical 返回类型的标准 slug, 未登记的类型返回 false
+func (r *GenreRegistry) Canonical(name string) (string, bool) {
+	genre := r.lookup(name)
+	if genre == nil {
+		return "", false
+	}
+
+	return genre.Slug, true
+}
+
+// Canonicalize 将已登记的类型替换为标准 slug, 未登记的类型保持不变, 用于搜索条件
+func (r *GenreRegistry) Canonicalize(names []string) []string {
+	slugs := make([]string, len(names))
+
+	for i, name := range names {
+		slugs[i] = name
+		if slug, ok := r.Canonical(name); ok {
+			slugs[i] = slug
+		}
+	}
+
+	return slugs
+}
+
+type GenreModel struct {
+	DB *sql.DB
+}
+
+// Insert 新增类型
+func (m GenreModel) Insert(genre *Genre) error {
+	query := `
+		INSERT INTO genres (slug, name, aliases)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{genre.Slug, genre.Name, pq.Array(genre.Aliases)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&genre.ID, &genre.CreatedAt, &genre.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "genres_slug_key"`:
+			return ErrDuplicateGenre
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m GenreModel) Get(id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, slug, name, aliases, version
+		FROM genres
+		WHERE id = $1`
+
+	var genre Genre
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&genre.ID,
+		&genre.CreatedAt,
+		&genre.Slug,
+		&genre.Name,
+		pq.Array(&genre.Aliases),
+		&genre.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &genre, nil
+}
+
+// GetAll 获取所有类型, 按名称排序
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `
+		SELECT id, created_at, slug, name, aliases, version
+		FROM genres
+		ORDER BY name ASC, id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(
+			&genre.ID,
+			&genre.CreatedAt,
+			&genre.Slug,
+			&genre.Name,
+			pq.Array(&genre.Aliases),
+			&genre.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// Registry 加载整个类型登记表
+func (m GenreModel) Registry() (*GenreRegistry, error) {
+	genres, err := m.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewGenreRegistry(genres), nil
+}
+
+// Update 更新类型, slug 变化时同时替换所有电影中的旧 slug, 受影响的电影增加版本号并记录修订, userID 为修改人
+func (m GenreModel) Update(genre *Genre, oldSlug string, userID int64) error {
+	query := `
+		UPDATE genres
+		SET slug = $1, name = $2, aliases = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	args := []interface{}{genre.Slug, genre.Name, pq.Array(genre.Aliases), genre.ID, genre.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&genre.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "genres_slug_key"`:
+			return ErrDuplicateGenre
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if genre.Slug != oldSlug {
+		query = `
+			UPDATE movies
+			SET genres = array_replace(genres, $1, $2), version = version + 1
+			WHERE genres @> ARRAY[$1]
+			RETURNING id, title, year, runtime, genres, version`
+
+		rows, err := tx.QueryContext(ctx, query, oldSlug, genre.Slug)
+		if err != nil {
+			return err
+		}
+
+		var movies []*Movie
+		for rows.Next() {
+			var movie Movie
+			err := rows.Scan(&movie.ID, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			movies = append(movies, &movie)
+		}
+		rows.Close()
+
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		// 每部受影响的电影都记录新的版本
+		for _, movie := range movies {
+			err = insertMovieRevision(ctx, tx, movie, userID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete 删除类型, 仍有电影 (包括回收站中的电影) 使用该类型时返回 ErrGenreInUse
+func (m GenreModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM genres
+		WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM movies WHERE movies.genres @> ARRAY[genres.slug])
+		RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&id)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	// 没有删除任何行时区分类型不存在和类型仍在使用
+	_, err = m.Get(id)
+	if err != nil {
+		return err
+	}
+
+	return ErrGenreInUse
+}
@@ -11,17 +11,35 @@ var (
 )
 
 type Models struct {
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Movies       MovieModel
+	Users        UserModel
+	Tokens       TokenModel
+	Permissions  PermissionModel
+	Reviews      ReviewModel
+	Revisions    MovieRevisionModel
+	People       PersonModel
+	Credits      CreditModel
+	Genres       GenreModel
+	Watchlist    WatchlistModel
+	Watched      WatchedModel
+	Titles       MovieTitleModel
+	EmailChanges EmailChangeModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
+		Movies:       MovieModel{DB: db},
+		Users:        UserModel{DB: db},
+		Tokens:       TokenModel{DB: db},
+		Permissions:  PermissionModel{DB: db},
+		Reviews:      ReviewModel{DB: db},
+		Revisions:    MovieRevisionModel{DB: db},
+		People:       PersonModel{DB: db},
+		Credits:      CreditModel{DB: db},
+		Genres:       GenreModel{DB: db},
+		Watchlist:    WatchlistModel{DB: db},
+		Watched:      WatchedModel{DB: db},
+		Titles:       MovieTitleModel{DB: db},
+		EmailChanges: EmailChangeModel{DB: db},
 	}
 }
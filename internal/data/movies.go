@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/lib/pq"
@@ -12,16 +13,30 @@ import (
 )
 
 type Movie struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`
-	Runtime   Runtime   `json:"runtime,omitempty,string"`
-	Genres    []string  `json:"genres,omitempty"`
-	Version   int32     `json:"version"`
+	ID            int64      `json:"id"`
+	CreatedAt     time.Time  `json:"-"`
+	Title         string     `json:"title"`
+	Year          int32      `json:"year,omitempty"`
+	Runtime       Runtime    `json:"runtime,omitempty,string"`
+	Genres        []string   `json:"genres,omitempty"`
+	AverageRating float64    `json:"average_rating"`
+	RatingCount   int32      `json:"rating_count"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+	Version       int32      `json:"version"`
+	// 海报在存储中的前缀, 为空表示没有海报
+	PosterKey  string     `json:"-"`
+	PosterURLs PosterURLs `json:"poster_urls,omitempty"`
+	// 使用 include=credits 时附带的演职人员
+	Credits []*Credit `json:"credits,omitempty"`
+	// 按标题搜索时返回相关度和高亮后的标题, 高亮的标题已经做了 HTML 转义
+	Relevance float64 `json:"relevance,omitempty"`
+	Highlight string  `json:"highlight,omitempty"`
+	// 标题被替换为译名时保存原标题
+	OriginalTitle string `json:"original_title,omitempty"`
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+// ValidateMovie 校验电影, 类型必须在登记表中, 别名会被替换为标准 slug
+func ValidateMovie(v *validator.Validator, movie *Movie, genres *GenreRegistry) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
 
@@ -35,6 +50,16 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Genres != nil, "genres", "must be provided")
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
+
+	for i, genre := range movie.Genres {
+		slug, ok := genres.Canonical(genre)
+		if !ok {
+			v.AddError("genres", fmt.Sprintf("contains unknown genre %q", genre))
+			continue
+		}
+		movie.Genres[i] = slug
+	}
+
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
 }
 
@@ -42,7 +67,8 @@ type MovieModel struct {
 	DB *sql.DB
 }
 
-func (m MovieModel) Insert(movie *Movie) error {
+// Insert 新增电影, 同时在同一个事务中记录第一个版本, userID 为操作的用户
+func (m MovieModel) Insert(movie *Movie, userID int64) error {
 	query := `
         INSERT INTO movies (title, year, runtime, genres) 
         VALUES ($1, $2, $3, $4)
@@ -53,7 +79,23 @@ func (m MovieModel) Insert(movie *Movie) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	err = insertMovieRevision(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (m MovieModel) Get(id int64) (*Movie, error) {
@@ -61,9 +103,9 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		return nil, ErrRecordNotFound
 	}
 	query := `
-        SELECT id, created_at, title, year, runtime, genres, version
+        SELECT id, created_at, title, year, runtime, genres, average_rating, rating_count, version, poster_key, poster_urls
         FROM movies
-        WHERE id = $1`
+        WHERE id = $1 AND deleted_at IS NULL`
 
 	var movie Movie
 
@@ -77,7 +119,11 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Year,
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
+		&movie.AverageRating,
+		&movie.RatingCount,
 		&movie.Version,
+		&movie.PosterKey,
+		&movie.PosterURLs,
 	)
 
 	if err != nil {
@@ -92,11 +138,12 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+// Update 更新电影, 使用 version 做乐观锁, 新版本在同一个事务中写入修订历史
+func (m MovieModel) Update(movie *Movie, userID int64) error {
 	query := `
         UPDATE movies 
         SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-        WHERE id = $5 AND version = $6
+        WHERE id = $5 AND version = $6 AND deleted_at IS NULL
         RETURNING version`
 	args := []interface{}{
 		movie.Title,
@@ -110,7 +157,13 @@ func (m MovieModel) Update(movie *Movie) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -119,22 +172,32 @@ func (m MovieModel) Update(movie *Movie) error {
 			return err
 		}
 	}
-	return nil
+
+	err = insertMovieRevision(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (m MovieModel) Delete(id int64) error {
+// Delete 删除电影, version 不为 0 时只有版本一致才会删除
+func (m MovieModel) Delete(id int64, version int32) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
+	// 软删除, 电影会进入回收站, 超过保留期限后由 PurgeDeleted 永久删除
 	query := `
-        DELETE FROM movies
-        WHERE id = $1`
+        UPDATE movies
+        SET deleted_at = NOW()
+        WHERE id = $1 AND deleted_at IS NULL
+        AND (version = $2 OR $2 = 0)`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id, version)
 	if err != nil {
 		return err
 	}
@@ -151,20 +214,244 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// GetAll 按搜索条件获取电影并分页, 标题支持前缀匹配和拼写错误
+// search.Facets 不为空时同时统计整个搜索结果 (不只是当前页) 的分面
+// fields 不为空时只选择这些字段对应的列
+func (m MovieModel) GetAll(search MovieSearch, filters Filters, fields []string) ([]*Movie, Metadata, error) {
+	movies, metadata, err := m.getAll(search, filters, fields)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	if len(search.Facets) > 0 {
+		metadata.Facets, err = m.facets(search)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	return movies, metadata, nil
+}
+
+// getAll 使用 LIMIT/OFFSET 或者游标分页获取电影列表
+func (m MovieModel) getAll(search MovieSearch, filters Filters, fields []string) ([]*Movie, Metadata, error) {
+	if filters.UseCursor {
+		return m.getAllByCursor(search, filters, fields)
+	}
+
+	selection := selectMovieColumns(fields, "id")
+
+	q := newMovieQuery(search)
+	limit, offset := q.arg(filters.limit()), q.arg(filters.offset())
+
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+	SELECT count(*) OVER(), %s, search.relevance, %s
+	%s
+	ORDER BY %s %s, movies.id ASC
+	LIMIT %s OFFSET %s`, selection.columns(), q.highlight, q.from(), filters.sortColumn(), filters.sortDirection(), limit, offset)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, q.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		dest := append([]interface{}{&totalRecords}, selection.dest(&movie)...)
+
+		err := rows.Scan(append(dest, &movie.Relevance, &movie.Highlight)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metaData := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return movies, metaData, err
+}
+
+// getAllByCursor 使用游标 (keyset) 分页获取电影列表
+// 多查询一行用来判断是否还有下一页
+func (m MovieModel) getAllByCursor(search MovieSearch, filters Filters, fields []string) ([]*Movie, Metadata, error) {
+	// 生成游标需要 id 和排序列
+	selection := selectMovieColumns(fields, "id", filters.sortColumn())
+
+	q := newMovieQuery(search)
+
+	if filters.Cursor != "" {
+		condition, err := filters.cursorCondition(q.arg)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		q.where(condition)
+	}
+
+	limit := q.arg(filters.limit() + 1)
+
+	query := fmt.Sprintf(`
+	SELECT %s, search.relevance, %s
+	%s
+	ORDER BY %s %s, movies.id ASC
+	LIMIT %s`, selection.columns(), q.highlight, q.from(), filters.sortColumn(), filters.sortDirection(), limit)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, q.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(append(selection.dest(&movie), &movie.Relevance, &movie.Highlight)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	var next *cursor
+	if len(movies) > filters.limit() {
+		movies = movies[:filters.limit()]
+		last := movies[len(movies)-1]
+		next = &cursor{Sort: filters.Sort, Value: last.sortValue(filters.sortColumn()), ID: last.ID}
+	}
+
+	return movies, calculateCursorMetadata(filters.PageSize, next), nil
+}
+
+// sortValue 返回排序列对应的值, 用于生成游标
+func (movie *Movie) sortValue(column string) string {
+	switch column {
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.FormatInt(int64(movie.Year), 10)
+	case "runtime":
+		return strconv.FormatInt(int64(movie.Runtime), 10)
+	case "average_rating":
+		return strconv.FormatFloat(movie.AverageRating, 'f', 2, 64)
+	case "relevance":
+		return strconv.FormatFloat(movie.Relevance, 'f', -1, 64)
+	default:
+		return strconv.FormatInt(movie.ID, 10)
+	}
+}
+
+// Export 按过滤和排序条件逐行读取所有电影并调用 fn, 不分页
+// 数据直接从数据库流式读取, fn 返回错误时停止读取
+func (m MovieModel) Export(ctx context.Context, search MovieSearch, filters Filters, fn func(*Movie) error) error {
+	q := newMovieQuery(search)
+
+	query := fmt.Sprintf(`
+	SELECT %s
+	%s
+	ORDER BY %s %s, movies.id ASC`, movieListColumns, q.from(), filters.sortColumn(), filters.sortDirection())
+
+	rows, err := m.DB.QueryContext(ctx, query, q.args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(scanMovieListColumns(&movie)...)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Restore 从回收站恢复电影
+func (m MovieModel) Restore(id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        UPDATE movies
+        SET deleted_at = NULL
+        WHERE id = $1 AND deleted_at IS NOT NULL
+        RETURNING id, created_at, title, year, runtime, genres, average_rating, rating_count, version, poster_urls`
+
+	var movie Movie
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.AverageRating,
+		&movie.RatingCount,
+		&movie.Version,
+		&movie.PosterURLs,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
+
+// GetAllDeleted 分页获取回收站中的电影
+func (m MovieModel) GetAllDeleted(filters Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, average_rating, rating_count, deleted_at, version, poster_urls
 	FROM movies
-	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') 
-	AND (genres @> $2 OR $2 = '{}')     
+	WHERE deleted_at IS NOT NULL
 	ORDER BY %s %s, id ASC
-	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+	LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -185,7 +472,11 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.DeletedAt,
 			&movie.Version,
+			&movie.PosterURLs,
 		)
 
 		if err != nil {
@@ -200,5 +491,124 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	}
 
 	metaData := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
-	return movies, metaData, err
+	return movies, metaData, nil
+}
+
+// PurgeDeleted 永久删除在回收站中超过 retention 的电影, 返回删除的行数和需要清理的海报前缀
+func (m MovieModel) PurgeDeleted(retention time.Duration) (int64, []string, error) {
+	query := `
+        DELETE FROM movies
+        WHERE deleted_at < $1
+        RETURNING poster_key`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var n int64
+	posterKeys := []string{}
+
+	for rows.Next() {
+		var posterKey string
+
+		err := rows.Scan(&posterKey)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		n++
+		if posterKey != "" {
+			posterKeys = append(posterKeys, posterKey)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	return n, posterKeys, nil
+}
+
+// MovieImport 在同一个事务中批量导入电影
+// partial 为 true 时每一行使用 savepoint, 单行插入失败不会影响其他行
+type MovieImport struct {
+	ctx     context.Context
+	tx      *sql.Tx
+	stmt    *sql.Stmt
+	userID  int64
+	partial bool
+}
+
+// BeginImport 开始一次批量导入, 调用方必须调用 Commit 或 Rollback
+func (m MovieModel) BeginImport(ctx context.Context, userID int64, partial bool) (*MovieImport, error) {
+	query := `
+        INSERT INTO movies (title, year, runtime, genres) 
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, created_at, version`
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return &MovieImport{ctx: ctx, tx: tx, stmt: stmt, userID: userID, partial: partial}, nil
+}
+
+// Insert 在导入事务中插入一部电影
+func (i *MovieImport) Insert(movie *Movie) error {
+	if !i.partial {
+		return i.insert(movie)
+	}
+
+	_, err := i.tx.ExecContext(i.ctx, "SAVEPOINT import_row")
+	if err != nil {
+		return err
+	}
+
+	err = i.insert(movie)
+	if err != nil {
+		_, rollbackErr := i.tx.ExecContext(i.ctx, "ROLLBACK TO SAVEPOINT import_row")
+		if rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	_, err = i.tx.ExecContext(i.ctx, "RELEASE SAVEPOINT import_row")
+	return err
+}
+
+// insert 插入电影和第一个版本的修订记录
+func (i *MovieImport) insert(movie *Movie) error {
+	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+
+	err := i.stmt.QueryRowContext(i.ctx, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	return insertMovieRevision(i.ctx, i.tx, movie, i.userID)
+}
+
+// Commit 提交导入事务
+func (i *MovieImport) Commit() error {
+	i.stmt.Close()
+	return i.tx.Commit()
+}
+
+// Rollback 回滚导入事务
+func (i *MovieImport) Rollback() error {
+	i.stmt.Close()
+	return i.tx.Rollback()
 }
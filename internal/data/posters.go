@@ -0,0 +1,87 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PosterURLs 海报各个尺寸的访问地址, 例如 {"small": "...", "original": "..."}
+type PosterURLs map[string]string
+
+// Scan 从 jsonb 列中读取
+func (p *PosterURLs) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*p = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(src, p)
+	case string:
+		return json.Unmarshal([]byte(src), p)
+	default:
+		return fmt.Errorf("cannot scan %T into PosterURLs", src)
+	}
+}
+
+// Value 保存为 jsonb
+func (p PosterURLs) Value() (driver.Value, error) {
+	if p == nil {
+		return []byte("{}"), nil
+	}
+
+	return json.Marshal(p)
+}
+
+// SetPoster 设置电影的海报, 增加版本号并记录修订
+// posterKey 为海报文件在存储中的前缀, 为空表示删除海报; 版本不一致时返回 ErrEditConflict
+func (m MovieModel) SetPoster(movie *Movie, posterKey string, urls PosterURLs, userID int64) error {
+	query := `
+        UPDATE movies
+        SET poster_key = $1, poster_urls = $2, version = version + 1
+        WHERE id = $3 AND version = $4 AND deleted_at IS NULL
+        RETURNING version`
+
+	if urls == nil {
+		urls = PosterURLs{}
+	}
+
+	args := []interface{}{posterKey, urls, movie.ID, movie.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = insertMovieRevision(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	movie.PosterKey = posterKey
+	movie.PosterURLs = urls
+	return nil
+}
@@ -0,0 +1,179 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MovieRevision 电影某个版本的完整内容
+// UserID 为空表示修改人未知 (历史数据或用户已删除)
+type MovieRevision struct {
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    *int64    `json:"user_id"`
+	Title     string    `json:"title"`
+	Year      int32     `json:"year"`
+	Runtime   Runtime   `json:"runtime"`
+	Genres    []string  `json:"genres"`
+}
+
+// RevisionChange 两个版本之间单个字段的变化
+type RevisionChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// DiffRevisions 返回从 from 到 to 发生变化的字段
+func DiffRevisions(from, to *MovieRevision) []RevisionChange {
+	changes := []RevisionChange{}
+
+	if from.Title != to.Title {
+		changes = append(changes, RevisionChange{Field: "title", From: from.Title, To: to.Title})
+	}
+
+	if from.Year != to.Year {
+		changes = append(changes, RevisionChange{Field: "year", From: from.Year, To: to.Year})
+	}
+
+	if from.Runtime != to.Runtime {
+		changes = append(changes, RevisionChange{Field: "runtime", From: from.Runtime, To: to.Runtime})
+	}
+
+	if !equalStrings(from.Genres, to.Genres) {
+		changes = append(changes, RevisionChange{Field: "genres", From: from.Genres, To: to.Genres})
+	}
+
+	return changes
+}
+
+// equalStrings 比较两个字符串列表的内容和顺序是否相同
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// insertMovieRevision 记录电影当前的版本, 需要和电影的修改在同一个事务中执行
+func insertMovieRevision(ctx context.Context, tx *sql.Tx, movie *Movie, userID int64) error {
+	query := `
+        INSERT INTO movie_revisions (movie_id, version, user_id, title, year, runtime, genres)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	// 没有用户时记录为 NULL
+	var user sql.NullInt64
+	if userID > 0 {
+		user = sql.NullInt64{Int64: userID, Valid: true}
+	}
+
+	args := []interface{}{movie.ID, movie.Version, user, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+type MovieRevisionModel struct {
+	DB *sql.DB
+}
+
+// Get 获取电影的某个版本
+func (m MovieRevisionModel) Get(movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT movie_id, version, created_at, user_id, title, year, runtime, genres
+        FROM movie_revisions
+        WHERE movie_id = $1 AND version = $2`
+
+	var revision MovieRevision
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&revision.MovieID,
+		&revision.Version,
+		&revision.CreatedAt,
+		&revision.UserID,
+		&revision.Title,
+		&revision.Year,
+		&revision.Runtime,
+		pq.Array(&revision.Genres),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+// GetAllForMovie 分页获取电影的修订历史
+func (m MovieRevisionModel) GetAllForMovie(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), movie_id, version, created_at, user_id, title, year, runtime, genres
+        FROM movie_revisions
+        WHERE movie_id = $1
+        ORDER BY %s %s
+        LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var revision MovieRevision
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.CreatedAt,
+			&revision.UserID,
+			&revision.Title,
+			&revision.Year,
+			&revision.Runtime,
+			pq.Array(&revision.Genres),
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return revisions, metadata, nil
+}
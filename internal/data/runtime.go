@@ -3,6 +3,7 @@ package data
 import (
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -10,6 +11,10 @@ import (
 // ErrInvalidRuntimeFormat 自定义解析错误
 var ErrInvalidRuntimeFormat = errors.New("invalid runtime format")
 
+// RuntimeFormats 可选的时长输出格式, 不指定时输出 "<n> mins"
+// minutes 输出分钟数, human 输出 "1h 42m", iso8601 输出 "PT1H42M"
+var RuntimeFormats = []string{"minutes", "human", "iso8601"}
+
 // Runtime 自定义电影上映日期类型
 type Runtime int32
 
@@ -20,23 +25,110 @@ func (r Runtime) MarshalJSON() ([]byte, error) {
 	return []byte(quotedJSONValue), nil
 }
 
+// UnmarshalJSON 接受整数分钟数, 或者 ParseRuntime 支持的字符串
 func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
 	if err != nil {
-		return ErrInvalidRuntimeFormat
-	}
+		i, err := strconv.ParseInt(string(jsonValue), 10, 32)
+		if err != nil {
+			return ErrInvalidRuntimeFormat
+		}
 
-	parts := strings.Split(unquotedJSONValue, " ")
-	if len(parts) != 2 || parts[1] != "mins" {
-		return ErrInvalidRuntimeFormat
+		*r = Runtime(i)
+		return nil
 	}
 
-	i, err := strconv.ParseInt(parts[0], 10, 32)
+	runtime, err := ParseRuntime(unquotedJSONValue)
 	if err != nil {
-		return ErrInvalidRuntimeFormat
+		return err
 	}
 
-	*r = Runtime(i)
+	*r = runtime
 
 	return nil
 }
+
+// Format 返回按 format 格式输出时使用的 JSON 值, format 为空时使用默认格式
+func (r Runtime) Format(format string) interface{} {
+	hours, minutes := r/60, r%60
+
+	switch format {
+	case "minutes":
+		return int32(r)
+	case "human":
+		switch {
+		case hours == 0:
+			return fmt.Sprintf("%dm", minutes)
+		case minutes == 0:
+			return fmt.Sprintf("%dh", hours)
+		default:
+			return fmt.Sprintf("%dh %dm", hours, minutes)
+		}
+	case "iso8601":
+		switch {
+		case hours == 0:
+			return fmt.Sprintf("PT%dM", minutes)
+		case minutes == 0:
+			return fmt.Sprintf("PT%dH", hours)
+		default:
+			return fmt.Sprintf("PT%dH%dM", hours, minutes)
+		}
+	default:
+		return r
+	}
+}
+
+// ParseRuntime 解析字符串形式的时长, 支持以下格式:
+// "102", "102 mins", "102 min", "1h 42m", "1h42m", "2h", 以及 ISO 8601 的 "PT1H42M"
+func ParseRuntime(s string) (Runtime, error) {
+	s = strings.TrimSpace(s)
+
+	if minutes, err := strconv.ParseInt(s, 10, 32); err == nil {
+		return Runtime(minutes), nil
+	}
+
+	if parts := strings.Fields(s); len(parts) == 2 && (parts[1] == "mins" || parts[1] == "min") {
+		minutes, err := strconv.ParseInt(parts[0], 10, 32)
+		if err != nil {
+			return 0, ErrInvalidRuntimeFormat
+		}
+		return Runtime(minutes), nil
+	}
+
+	upper := strings.ToUpper(s)
+	if strings.HasPrefix(upper, "PT") {
+		return parseRuntimeUnits(upper[2:], "H", "M")
+	}
+
+	return parseRuntimeUnits(strings.Join(strings.Fields(s), ""), "h", "m")
+}
+
+// parseRuntimeUnits 解析 "1h42m" 形式的时长, 小时和分钟都可以省略但不能都省略, 小时必须在分钟之前
+func parseRuntimeUnits(s, hourUnit, minuteUnit string) (Runtime, error) {
+	var total int64
+	units := []string{hourUnit, minuteUnit}
+	multipliers := []int64{60, 1}
+	parsed := false
+
+	for i, unit := range units {
+		end := strings.Index(s, unit)
+		if end < 0 {
+			continue
+		}
+
+		n, err := strconv.ParseUint(s[:end], 10, 32)
+		if err != nil {
+			return 0, ErrInvalidRuntimeFormat
+		}
+
+		total += int64(n) * multipliers[i]
+		s = s[end+len(unit):]
+		parsed = true
+	}
+
+	if !parsed || s != "" || total > math.MaxInt32 {
+		return 0, ErrInvalidRuntimeFormat
+	}
+
+	return Runtime(total), nil
+}
@@ -0,0 +1,279 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+	"github.com/liliang-cn/greenlight/internal/validator"
+)
+
+// MovieSearch 电影列表的搜索条件, 数值为 0 或时间为零值表示不限制
+type MovieSearch struct {
+	Title  string
+	Genres []string
+	// GenresMode 类型的匹配方式: all 包含所有类型, any 包含任意一个, none 不包含任何一个
+	GenresMode    string
+	YearMin       int
+	YearMax       int
+	RuntimeMin    int
+	RuntimeMax    int
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// PersonID 只返回该演职人员参与的电影
+	PersonID int64
+	// OnWatchlist 不为空时只返回 (或排除) UserID 待看列表中的电影
+	OnWatchlist *bool
+	UserID      int64
+	// Facets 需要统计的分面
+	Facets []string
+}
+
+// FacetSafelist 允许统计的分面: genres 按类型统计, year 按年代统计
+var FacetSafelist = []string{"genres", "year"}
+
+// ValidateMovieSearch 校验搜索条件
+func ValidateMovieSearch(v *validator.Validator, search MovieSearch) {
+	v.Check(validator.In(search.GenresMode, "all", "any", "none"), "genres_mode", "must be all, any or none")
+
+	v.Check(search.YearMin >= 0, "year_min", "must not be negative")
+	v.Check(search.YearMax >= 0, "year_max", "must not be negative")
+	v.Check(search.YearMax == 0 || search.YearMin <= search.YearMax, "year_max", "must not be less than year_min")
+
+	v.Check(search.RuntimeMin >= 0, "runtime_min", "must not be negative")
+	v.Check(search.RuntimeMax >= 0, "runtime_max", "must not be negative")
+	v.Check(search.RuntimeMax == 0 || search.RuntimeMin <= search.RuntimeMax, "runtime_max", "must not be less than runtime_min")
+
+	v.Check(search.CreatedBefore.IsZero() || search.CreatedAfter.Before(search.CreatedBefore), "created_before", "must be later than created_after")
+
+	v.Check(search.PersonID >= 0, "person_id", "must not be negative")
+
+	for _, facet := range search.Facets {
+		v.Check(validator.In(facet, FacetSafelist...), "facets", "must only contain genres or year")
+	}
+	v.Check(validator.Unique(search.Facets), "facets", "must not contain duplicate values")
+}
+
+// FacetCount 分面中某个值匹配的电影数量
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// movieListColumns 电影列表查询返回的列
+const movieListColumns = `movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.average_rating, movies.rating_count, movies.version, movies.poster_urls`
+
+// scanMovieListColumns 返回扫描 movieListColumns 需要的目标
+func scanMovieListColumns(movie *Movie) []interface{} {
+	return []interface{}{
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.AverageRating,
+		&movie.RatingCount,
+		&movie.Version,
+		&movie.PosterURLs,
+	}
+}
+
+// movieQuery 拼接电影列表查询的条件和参数, 占位符按照参数添加的顺序编号
+type movieQuery struct {
+	args       []interface{}
+	conditions []string
+	// search 计算标题搜索相关度的 LATERAL 子查询, 提供 relevance 列用于排序
+	search string
+	// highlight 标题高亮片段的表达式
+	highlight string
+}
+
+// arg 添加一个参数并返回对应的占位符
+func (q *movieQuery) arg(value interface{}) string {
+	q.args = append(q.args, value)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// where 添加一个 AND 条件
+func (q *movieQuery) where(condition string) {
+	q.conditions = append(q.conditions, condition)
+}
+
+// from 返回 FROM 和 WHERE 子句
+func (q *movieQuery) from() string {
+	return fmt.Sprintf(`FROM movies
+	CROSS JOIN LATERAL %s
+	WHERE %s`, q.search, strings.Join(q.conditions, "\n\tAND "))
+}
+
+// escapedMovieTitle HTML 转义后的电影标题, 与 html.EscapeString 的结果相同
+// 高亮片段中只有 <mark> 标签是 HTML, 客户端可以直接渲染
+const escapedMovieTitle = `replace(replace(replace(replace(replace(movies.title, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;')`
+
+// newMovieQuery 根据搜索条件生成查询条件, 只包含未删除的电影
+// 标题同时使用全文搜索 (最后一个词做前缀匹配) 和 pg_trgm 的词相似度, 拼写错误的标题也可以匹配
+// 原标题或任意一个译名匹配即可, 相关度取所有标题中最高的
+func newMovieQuery(search MovieSearch) *movieQuery {
+	title, genres := search.Title, search.Genres
+
+	q := &movieQuery{
+		search:    "(SELECT 0::numeric AS relevance) AS search",
+		highlight: "''",
+	}
+
+	q.where("movies.deleted_at IS NULL")
+
+	if title = strings.TrimSpace(title); title != "" {
+		t := q.arg(title)
+
+		// 电影的原标题和所有译名
+		titles := "(SELECT movies.title UNION ALL SELECT movie_titles.title FROM movie_titles WHERE movie_titles.movie_id = movies.id) AS titles (title)"
+		similarity := fmt.Sprintf("word_similarity(%s, titles.title)", t)
+
+		if tsquery := titleTSQuery(title); tsquery != "" {
+			query := fmt.Sprintf("to_tsquery('simple', %s)", q.arg(tsquery))
+
+			q.where(fmt.Sprintf(`(to_tsvector('simple', movies.title) @@ %[1]s OR %[2]s <%% movies.title
+		OR movies.id IN (SELECT movie_titles.movie_id FROM movie_titles WHERE to_tsvector('simple', movie_titles.title) @@ %[1]s OR %[2]s <%% movie_titles.title))`, query, t))
+			q.search = fmt.Sprintf("(SELECT round(max(ts_rank(to_tsvector('simple', titles.title), %s) + %s)::numeric, 6) AS relevance FROM %s) AS search", query, similarity, titles)
+			q.highlight = fmt.Sprintf("ts_headline('simple', %s, %s, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true')", escapedMovieTitle, query)
+		} else {
+			q.where(fmt.Sprintf(`(%[1]s <%% movies.title
+		OR movies.id IN (SELECT movie_titles.movie_id FROM movie_titles WHERE %[1]s <%% movie_titles.title))`, t))
+			q.search = fmt.Sprintf("(SELECT round(max(%s)::numeric, 6) AS relevance FROM %s) AS search", similarity, titles)
+		}
+	}
+
+	if len(genres) > 0 {
+		switch search.GenresMode {
+		case "any":
+			q.where(fmt.Sprintf("movies.genres && %s", q.arg(pq.Array(genres))))
+		case "none":
+			q.where(fmt.Sprintf("NOT movies.genres && %s", q.arg(pq.Array(genres))))
+		default:
+			q.where(fmt.Sprintf("movies.genres @> %s", q.arg(pq.Array(genres))))
+		}
+	}
+
+	if search.YearMin > 0 {
+		q.where(fmt.Sprintf("movies.year >= %s", q.arg(search.YearMin)))
+	}
+
+	if search.YearMax > 0 {
+		q.where(fmt.Sprintf("movies.year <= %s", q.arg(search.YearMax)))
+	}
+
+	if search.RuntimeMin > 0 {
+		q.where(fmt.Sprintf("movies.runtime >= %s", q.arg(search.RuntimeMin)))
+	}
+
+	if search.RuntimeMax > 0 {
+		q.where(fmt.Sprintf("movies.runtime <= %s", q.arg(search.RuntimeMax)))
+	}
+
+	if !search.CreatedAfter.IsZero() {
+		q.where(fmt.Sprintf("movies.created_at >= %s", q.arg(search.CreatedAfter)))
+	}
+
+	if !search.CreatedBefore.IsZero() {
+		q.where(fmt.Sprintf("movies.created_at < %s", q.arg(search.CreatedBefore)))
+	}
+
+	if search.PersonID > 0 {
+		q.where(fmt.Sprintf("EXISTS (SELECT 1 FROM movie_credits WHERE movie_credits.movie_id = movies.id AND movie_credits.person_id = %s)", q.arg(search.PersonID)))
+	}
+
+	if search.OnWatchlist != nil {
+		condition := fmt.Sprintf("EXISTS (SELECT 1 FROM watchlist WHERE watchlist.movie_id = movies.id AND watchlist.user_id = %s)", q.arg(search.UserID))
+		if !*search.OnWatchlist {
+			condition = "NOT " + condition
+		}
+		q.where(condition)
+	}
+
+	return q
+}
+
+// titleTSQuery 将搜索词转换为 tsquery, 所有词都需要匹配, 最后一个词做前缀匹配
+// 只保留字母和数字, 避免客户端输入 tsquery 的运算符
+func titleTSQuery(title string) string {
+	words := strings.FieldsFunc(title, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	if len(words) == 0 {
+		return ""
+	}
+
+	words[len(words)-1] += ":*"
+	return strings.Join(words, " & ")
+}
+
+// facetQueries 每个分面的统计查询, %s 为 movieQuery 生成的 FROM/WHERE 子句
+var facetQueries = map[string]string{
+	"genres": `
+	SELECT facet.genre, count(*)
+	FROM (SELECT unnest(movies.genres) AS genre
+	%s) AS facet
+	GROUP BY facet.genre
+	ORDER BY count(*) DESC, facet.genre ASC`,
+	"year": `
+	SELECT (movies.year / 10 * 10)::text || 's' AS decade, count(*)
+	%s
+	GROUP BY decade
+	ORDER BY decade ASC`,
+}
+
+// facets 统计整个搜索结果中每个类型和年代的电影数量, 使用与列表相同的搜索条件
+func (m MovieModel) facets(search MovieSearch) (map[string][]FacetCount, error) {
+	facets := make(map[string][]FacetCount, len(search.Facets))
+
+	for _, name := range search.Facets {
+		q := newMovieQuery(search)
+		query := fmt.Sprintf(facetQueries[name], q.from())
+
+		counts, err := m.facetCounts(query, q.args)
+		if err != nil {
+			return nil, err
+		}
+
+		facets[name] = counts
+	}
+
+	return facets, nil
+}
+
+// facetCounts 执行分面统计查询
+func (m MovieModel) facetCounts(query string, args []interface{}) ([]FacetCount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []FacetCount{}
+
+	for rows.Next() {
+		var count FacetCount
+
+		err := rows.Scan(&count.Value, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, count)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,66 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// GetSimilar 分页获取与 movie 相似的电影, 不包含 movie 本身, 只考虑至少有一个相同类型的电影
+// 相似度保存在 Relevance 中, 由以下几项相加:
+// 类型的 Jaccard 系数 (权重 3), 年份和时长的接近程度 (相差 5 年或 15 分钟时为 0.5), 标题的 trigram 相似度
+func (m MovieModel) GetSimilar(movie *Movie, filters Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), %s, similar.relevance
+	FROM movies
+	CROSS JOIN LATERAL (
+		SELECT cardinality(ARRAY(SELECT unnest(movies.genres) INTERSECT SELECT unnest($2::text[]))) AS shared
+	) AS overlap
+	CROSS JOIN LATERAL (
+		SELECT round((
+			3.0 * overlap.shared / (cardinality(movies.genres) + cardinality($2::text[]) - overlap.shared)
+			+ 1.0 / (1 + abs(movies.year - $3) / 5.0)
+			+ 1.0 / (1 + abs(movies.runtime - $4) / 15.0)
+			+ similarity(movies.title, $5)
+		)::numeric, 6) AS relevance
+	) AS similar
+	WHERE movies.id <> $1 AND movies.deleted_at IS NULL AND movies.genres && $2::text[]
+	ORDER BY similar.relevance DESC, movies.id ASC
+	LIMIT $6 OFFSET $7`, movieListColumns)
+
+	args := []interface{}{movie.ID, pq.Array(movie.Genres), movie.Year, movie.Runtime, movie.Title, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var similar Movie
+
+		dest := append([]interface{}{&totalRecords}, scanMovieListColumns(&similar)...)
+
+		err := rows.Scan(append(dest, &similar.Relevance)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &similar)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return movies, metadata, nil
+}
@@ -0,0 +1,254 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/liliang-cn/greenlight/internal/validator"
+)
+
+// LanguageTagRX BCP 47 语言标签: 2-3 位字母的主语言, 后面可以有多个 1-8 位字母或数字的子标签
+var LanguageTagRX = regexp.MustCompile("^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{1,8})*$")
+
+// MovieTitle 电影在某种语言中的译名
+type MovieTitle struct {
+	ID       int64  `json:"id"`
+	MovieID  int64  `json:"movie_id"`
+	Language string `json:"language"`
+	Title    string `json:"title"`
+}
+
+// ValidateMovieTitle 校验译名
+func ValidateMovieTitle(v *validator.Validator, title *MovieTitle) {
+	v.Check(title.Language != "", "language", "must be provided")
+	v.Check(len(title.Language) <= 35, "language", "must not be more than 35 bytes long")
+	v.Check(validator.Matches(title.Language, LanguageTagRX), "language", "must be a valid BCP 47 language tag")
+
+	v.Check(title.Title != "", "title", "must be provided")
+	v.Check(len(title.Title) <= 500, "title", "must not be more than 500 bytes long")
+}
+
+// CanonicalLanguageTag 按 BCP 47 的大小写约定格式化语言标签, 例如 zh-hant-tw 转换为 zh-Hant-TW
+// 主语言小写, 4 位的文字首字母大写, 2 位字母的地区大写, 扩展 (单字符子标签) 之后全部小写
+func CanonicalLanguageTag(tag string) string {
+	subtags := strings.Split(strings.ToLower(tag), "-")
+
+	for i := 1; i < len(subtags); i++ {
+		subtag := subtags[i]
+
+		if len(subtag) == 1 {
+			break
+		}
+
+		switch {
+		case len(subtag) == 4 && i == 1:
+			subtags[i] = strings.ToUpper(subtag[:1]) + subtag[1:]
+		case len(subtag) == 2:
+			subtags[i] = strings.ToUpper(subtag)
+		}
+	}
+
+	return strings.Join(subtags, "-")
+}
+
+// languageFallbacks 返回按 RFC 4647 lookup 规则依次回退的候选语言, 例如 zh-Hant-TW, zh-Hant, zh
+// 为 * 时返回 nil, 表示使用原标题
+func languageFallbacks(tag string) []string {
+	if tag == "*" {
+		return nil
+	}
+
+	tag = CanonicalLanguageTag(tag)
+	fallbacks := []string{tag}
+
+	for {
+		i := strings.LastIndex(tag, "-")
+		if i < 0 {
+			return fallbacks
+		}
+
+		tag = tag[:i]
+		// 回退时去掉末尾的单字符子标签
+		if len(tag) >= 2 && tag[len(tag)-2] == '-' {
+			tag = tag[:len(tag)-2]
+		}
+
+		fallbacks = append(fallbacks, tag)
+	}
+}
+
+type MovieTitleModel struct {
+	DB *sql.DB
+}
+
+// Upsert 新增或替换电影在某种语言中的译名, 返回是否为新增
+func (m MovieTitleModel) Upsert(title *MovieTitle) (bool, error) {
+	query := `
+		INSERT INTO movie_titles (movie_id, language, title)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (movie_id, language) DO UPDATE SET title = EXCLUDED.title
+		RETURNING id, xmax = 0`
+
+	args := []interface{}{title.MovieID, title.Language, title.Title}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var inserted bool
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&title.ID, &inserted)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "movie_titles" violates foreign key constraint "movie_titles_movie_id_fkey"`:
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+
+	return inserted, nil
+}
+
+// GetForMovie 获取电影的所有译名, 按语言排序
+func (m MovieTitleModel) GetForMovie(movieID int64) ([]*MovieTitle, error) {
+	query := `
+		SELECT id, movie_id, language, title
+		FROM movie_titles
+		WHERE movie_id = $1
+		ORDER BY language`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	titles := []*MovieTitle{}
+
+	for rows.Next() {
+		var title MovieTitle
+
+		err := rows.Scan(&title.ID, &title.MovieID, &title.Language, &title.Title)
+		if err != nil {
+			return nil, err
+		}
+
+		titles = append(titles, &title)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return titles, nil
+}
+
+// Delete 删除电影在某种语言中的译名
+func (m MovieTitleModel) Delete(movieID int64, language string) error {
+	if movieID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM movie_titles
+		WHERE movie_id = $1 AND language = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, language)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Localize 将电影的标题替换为 languages (按优先级排列) 中最合适的译名, 原标题保存在 OriginalTitle
+// 返回每部电影使用的语言, 没有合适译名的电影为空字符串
+func (m MovieTitleModel) Localize(movies []*Movie, languages []string) ([]string, error) {
+	used := make([]string, len(movies))
+
+	// 依次回退的候选语言, 遇到 * 时之后的语言都不再考虑
+	var candidates []string
+	for _, language := range languages {
+		fallbacks := languageFallbacks(language)
+		if fallbacks == nil {
+			break
+		}
+		candidates = append(candidates, fallbacks...)
+	}
+
+	if len(movies) == 0 || len(candidates) == 0 {
+		return used, nil
+	}
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	query := `
+		SELECT movie_id, language, title
+		FROM movie_titles
+		WHERE movie_id = ANY($1) AND language = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids), pq.Array(candidates))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	titles := make(map[int64]map[string]string)
+
+	for rows.Next() {
+		var movieID int64
+		var language, title string
+
+		err := rows.Scan(&movieID, &language, &title)
+		if err != nil {
+			return nil, err
+		}
+
+		if titles[movieID] == nil {
+			titles[movieID] = make(map[string]string)
+		}
+		titles[movieID][language] = title
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, movie := range movies {
+		for _, language := range candidates {
+			if title, ok := titles[movie.ID][language]; ok {
+				movie.OriginalTitle = movie.Title
+				movie.Title = title
+				used[i] = language
+				break
+			}
+		}
+	}
+
+	return used, nil
+}
@@ -14,8 +14,13 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeEmailChange    = "email-change"
 )
 
+// TokenScopes 所有的 Token 用途
+var TokenScopes = []string{ScopeActivation, ScopeAuthentication, ScopePasswordReset, ScopeEmailChange}
+
 type Token struct {
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
@@ -93,3 +98,17 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteAllForUserExcept 删除用户除 tokenPlaintext 之外的 Token, 例如修改密码时保留当前的登录状态
+func (m TokenModel) DeleteAllForUserExcept(scope string, userID int64, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	DELETE FROM tokens WHERE scope = $1 AND user_id = $2 AND hash <> $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, userID, tokenHash[:])
+	return err
+}
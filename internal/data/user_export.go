@@ -0,0 +1,199 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// UserExport 用户的个人数据, 包含保存的所有与用户有关的数据, Token 只导出用途和过期时间
+type UserExport struct {
+	ExportedAt   time.Time                 `json:"exported_at"`
+	User         *User                     `json:"user"`
+	Permissions  Permissions               `json:"permissions"`
+	Tokens       []*exportedToken          `json:"tokens"`
+	PendingEmail string                    `json:"pending_email,omitempty"`
+	Reviews      []*Review                 `json:"reviews"`
+	Watchlist    []*exportedWatchlistEntry `json:"watchlist"`
+	Watched      []*exportedWatchedEntry   `json:"watched"`
+	Revisions    []*MovieRevision          `json:"revisions"`
+}
+
+type exportedToken struct {
+	Scope  string    `json:"scope"`
+	Expiry time.Time `json:"expiry"`
+}
+
+type exportedWatchlistEntry struct {
+	MovieID    int64     `json:"movie_id"`
+	MovieTitle string    `json:"movie_title"`
+	AddedAt    time.Time `json:"added_at"`
+}
+
+type exportedWatchedEntry struct {
+	ID         int64  `json:"id"`
+	MovieID    int64  `json:"movie_id"`
+	MovieTitle string `json:"movie_title"`
+	WatchedOn  string `json:"watched_on"`
+}
+
+// Export 导出用户的所有数据, 所有查询在同一个只读事务中执行, 保证数据一致
+// 包含回收站中电影的评论和观看记录
+func (m UserModel) Export(user *User) (*UserExport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	export := &UserExport{
+		ExportedAt:  time.Now(),
+		User:        user,
+		Permissions: Permissions{},
+		Tokens:      []*exportedToken{},
+		Reviews:     []*Review{},
+		Watchlist:   []*exportedWatchlistEntry{},
+		Watched:     []*exportedWatchedEntry{},
+		Revisions:   []*MovieRevision{},
+	}
+
+	err = exportRows(ctx, tx, `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON permissions.id = users_permissions.permission_id
+		WHERE users_permissions.user_id = $1
+		ORDER BY permissions.code`, user.ID, func(rows *sql.Rows) error {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return err
+		}
+		export.Permissions = append(export.Permissions, code)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = exportRows(ctx, tx, `
+		SELECT scope, expiry
+		FROM tokens
+		WHERE user_id = $1
+		ORDER BY expiry`, user.ID, func(rows *sql.Rows) error {
+		var token exportedToken
+		if err := rows.Scan(&token.Scope, &token.Expiry); err != nil {
+			return err
+		}
+		export.Tokens = append(export.Tokens, &token)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT email FROM email_changes WHERE user_id = $1`, user.ID).Scan(&export.PendingEmail)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	err = exportRows(ctx, tx, `
+		SELECT id, created_at, movie_id, user_id, rating, body, version
+		FROM reviews
+		WHERE user_id = $1
+		ORDER BY id`, user.ID, func(rows *sql.Rows) error {
+		var review Review
+		err := rows.Scan(&review.ID, &review.CreatedAt, &review.MovieID, &review.UserID, &review.Rating, &review.Body, &review.Version)
+		if err != nil {
+			return err
+		}
+		export.Reviews = append(export.Reviews, &review)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = exportRows(ctx, tx, `
+		SELECT watchlist.movie_id, movies.title, watchlist.added_at
+		FROM watchlist
+		INNER JOIN movies ON movies.id = watchlist.movie_id
+		WHERE watchlist.user_id = $1
+		ORDER BY watchlist.added_at`, user.ID, func(rows *sql.Rows) error {
+		var entry exportedWatchlistEntry
+		if err := rows.Scan(&entry.MovieID, &entry.MovieTitle, &entry.AddedAt); err != nil {
+			return err
+		}
+		export.Watchlist = append(export.Watchlist, &entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = exportRows(ctx, tx, `
+		SELECT watched_movies.id, watched_movies.movie_id, movies.title, to_char(watched_movies.watched_on, 'YYYY-MM-DD')
+		FROM watched_movies
+		INNER JOIN movies ON movies.id = watched_movies.movie_id
+		WHERE watched_movies.user_id = $1
+		ORDER BY watched_movies.watched_on, watched_movies.id`, user.ID, func(rows *sql.Rows) error {
+		var entry exportedWatchedEntry
+		if err := rows.Scan(&entry.ID, &entry.MovieID, &entry.MovieTitle, &entry.WatchedOn); err != nil {
+			return err
+		}
+		export.Watched = append(export.Watched, &entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = exportRows(ctx, tx, `
+		SELECT movie_id, version, created_at, user_id, title, year, runtime, genres
+		FROM movie_revisions
+		WHERE user_id = $1
+		ORDER BY created_at, movie_id, version`, user.ID, func(rows *sql.Rows) error {
+		var revision MovieRevision
+		err := rows.Scan(
+			&revision.MovieID,
+			&revision.Version,
+			&revision.CreatedAt,
+			&revision.UserID,
+			&revision.Title,
+			&revision.Year,
+			&revision.Runtime,
+			pq.Array(&revision.Genres),
+		)
+		if err != nil {
+			return err
+		}
+		export.Revisions = append(export.Revisions, &revision)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return export, tx.Commit()
+}
+
+// exportRows 执行只有一个用户 id 参数的查询, 对每一行调用 scan
+func exportRows(ctx context.Context, tx *sql.Tx, query string, userID int64, scan func(*sql.Rows) error) error {
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,265 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/liliang-cn/greenlight/internal/validator"
+)
+
+// WatchlistEntry 用户待看列表中的电影
+type WatchlistEntry struct {
+	AddedAt time.Time `json:"added_at"`
+	Movie   *Movie    `json:"movie"`
+}
+
+// WatchedEntry 用户的观看记录, 同一部电影可以多次观看
+type WatchedEntry struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"-"`
+	MovieID   int64  `json:"-"`
+	WatchedOn string `json:"watched_on"`
+	Movie     *Movie `json:"movie,omitempty"`
+}
+
+// ValidateWatchedEntry 校验观看记录, 观看日期格式为 2006-01-02 且不能晚于明天 (允许时区差异)
+func ValidateWatchedEntry(v *validator.Validator, entry *WatchedEntry) {
+	v.Check(entry.MovieID > 0, "movie_id", "must be provided")
+
+	v.Check(entry.WatchedOn != "", "watched_on", "must be provided")
+
+	watchedOn, err := time.Parse("2006-01-02", entry.WatchedOn)
+	if err != nil {
+		v.AddError("watched_on", "must be a date in the format 2006-01-02")
+		return
+	}
+
+	v.Check(watchedOn.Year() >= 1888, "watched_on", "must be later than 1888")
+	v.Check(watchedOn.Before(time.Now().AddDate(0, 0, 1)), "watched_on", "must not be in the future")
+}
+
+type WatchlistModel struct {
+	DB *sql.DB
+}
+
+// Add 将电影加入待看列表, 已经在列表中时保持原来的加入时间
+// 电影在此期间被删除时返回 ErrRecordNotFound
+func (m WatchlistModel) Add(userID int64, movie *Movie) (*WatchlistEntry, error) {
+	query := `
+		INSERT INTO watchlist (user_id, movie_id)
+		SELECT $1::bigint, $2::bigint
+		WHERE EXISTS (SELECT 1 FROM movies WHERE id = $2 AND deleted_at IS NULL)
+		ON CONFLICT (user_id, movie_id) DO UPDATE SET added_at = watchlist.added_at
+		RETURNING added_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	entry := &WatchlistEntry{Movie: movie}
+
+	err := m.DB.QueryRowContext(ctx, query, userID, movie.ID).Scan(&entry.AddedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return entry, nil
+}
+
+// Remove 将电影移出待看列表
+func (m WatchlistModel) Remove(userID, movieID int64) error {
+	if movieID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM watchlist
+		WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAll 分页获取用户的待看列表, 不包含回收站中的电影
+func (m WatchlistModel) GetAll(userID int64, filters Filters) ([]*WatchlistEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), watchlist.added_at, %s
+		FROM watchlist
+		INNER JOIN movies ON movies.id = watchlist.movie_id
+		WHERE watchlist.user_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY %s %s, movies.id ASC
+		LIMIT $2 OFFSET $3`, movieListColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchlistEntry{}
+
+	for rows.Next() {
+		entry := WatchlistEntry{Movie: &Movie{}}
+
+		dest := append([]interface{}{&totalRecords, &entry.AddedAt}, scanMovieListColumns(entry.Movie)...)
+
+		err := rows.Scan(dest...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return entries, metadata, nil
+}
+
+type WatchedModel struct {
+	DB *sql.DB
+}
+
+// Insert 新增观看记录, 同时将电影移出待看列表
+// 电影不存在或在回收站中时返回 ErrRecordNotFound
+func (m WatchedModel) Insert(entry *WatchedEntry) error {
+	query := `
+		INSERT INTO watched_movies (user_id, movie_id, watched_on)
+		SELECT $1::bigint, $2::bigint, $3::date
+		WHERE EXISTS (SELECT 1 FROM movies WHERE id = $2 AND deleted_at IS NULL)
+		RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, entry.UserID, entry.MovieID, entry.WatchedOn).Scan(&entry.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query = `
+		DELETE FROM watchlist
+		WHERE user_id = $1 AND movie_id = $2`
+
+	_, err = tx.ExecContext(ctx, query, entry.UserID, entry.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete 删除用户的一条观看记录
+func (m WatchedModel) Delete(userID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM watched_movies
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAll 分页获取用户的观看记录, 不包含回收站中的电影
+func (m WatchedModel) GetAll(userID int64, filters Filters) ([]*WatchedEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), watched_movies.id, watched_movies.user_id, watched_movies.movie_id,
+		       to_char(watched_movies.watched_on, 'YYYY-MM-DD'), %s
+		FROM watched_movies
+		INNER JOIN movies ON movies.id = watched_movies.movie_id
+		WHERE watched_movies.user_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY %s %s, watched_movies.id DESC
+		LIMIT $2 OFFSET $3`, movieListColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchedEntry{}
+
+	for rows.Next() {
+		entry := WatchedEntry{Movie: &Movie{}}
+
+		dest := append([]interface{}{&totalRecords, &entry.ID, &entry.UserID, &entry.MovieID, &entry.WatchedOn}, scanMovieListColumns(entry.Movie)...)
+
+		err := rows.Scan(dest...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return entries, metadata, nil
+}
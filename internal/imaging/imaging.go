@@ -0,0 +1,128 @@
+// Package imaging 解码上传的图片并生成缩略图, 只依赖标准库
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+
+	// 注册 GIF 和 PNG 解码器
+	_ "image/gif"
+	_ "image/png"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrTooLarge          = errors.New("image dimensions are too large")
+)
+
+// MaxDimension 图片宽和高的最大像素数, 避免解码时占用过多内存
+const MaxDimension = 8000
+
+// MaxPixels 图片的最大总像素数, 解码和 Flatten 后的图片每个像素各占 4 字节
+const MaxPixels = 25_000_000
+
+// Decode 解码 JPEG, PNG 或 GIF 图片, 返回图片和格式名称
+// 解码前先读取尺寸, 拒绝宽高或总像素数过大的图片
+func Decode(data []byte) (image.Image, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, "", ErrUnsupportedFormat
+		}
+		return nil, "", err
+	}
+
+	if config.Width > MaxDimension || config.Height > MaxDimension || config.Width*config.Height > MaxPixels {
+		return nil, "", ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return img, format, nil
+}
+
+// Resize 按宽度等比例缩小 Flatten 返回的图片, 每个像素取对应区域内像素的平均值
+// 图片宽度不超过 width 时不放大, 直接返回 src
+func Resize(src *image.RGBA, width int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if sw <= width || sw == 0 {
+		return src
+	}
+
+	height := sh * width / sw
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0, y1 := span(y, height, sh)
+
+		for x := 0; x < width; x++ {
+			x0, x1 := span(x, width, sw)
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(src.Pix[i])
+					g += uint32(src.Pix[i+1])
+					b += uint32(src.Pix[i+2])
+					a += uint32(src.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// span 返回目标第 i 个像素 (共 dst 个) 对应的源像素范围 [start, end)
+func span(i, dst, src int) (int, int) {
+	start := i * src / dst
+	end := (i + 1) * src / dst
+	if end <= start {
+		end = start + 1
+	}
+
+	return start, end
+}
+
+// Flatten 将图片绘制到白色背景上, 返回从 (0, 0) 开始的 RGBA 图片
+// 生成多个尺寸时只需要调用一次
+func Flatten(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Over)
+
+	return dst
+}
+
+// EncodeJPEG 将图片编码为 JPEG
+func EncodeJPEG(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,373 @@
+// Package jsonpatch 实现 JSON Patch (RFC 6902) 和 JSON Merge Patch (RFC 7396)
+// 文档为 json.Unmarshal 到 interface{} 得到的值
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrTestFailed test 操作的值与文档中的值不相等
+	ErrTestFailed = errors.New("test operation failed")
+
+	errNotFound = errors.New("not found")
+)
+
+// Error 操作无法应用到文档上时返回的错误
+type Error struct {
+	Index   int
+	Message string
+	err     error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("operation %d: %s", e.Index, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Operation RFC 6902 中的一个操作, Value 保留原始 JSON 以区分缺失和 null
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Apply 依次将 patch 中的操作应用到 doc 上并返回新的文档
+// 出错时 doc 可能已经被部分修改, 调用方应当丢弃
+func Apply(doc interface{}, patch []Operation) (interface{}, error) {
+	for i, op := range patch {
+		var err error
+
+		doc, err = op.apply(doc)
+		if err != nil {
+			var patchErr *Error
+			if errors.As(err, &patchErr) {
+				patchErr.Index = i
+				return nil, patchErr
+			}
+			return nil, &Error{Index: i, Message: err.Error(), err: err}
+		}
+	}
+
+	return doc, nil
+}
+
+func (op Operation) apply(doc interface{}) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		return op.wrap(add(doc, path, value))
+
+	case "remove":
+		if len(path) == 0 {
+			return nil, errors.New("cannot remove the whole document")
+		}
+		doc, _, err = remove(doc, path)
+		return op.wrap(doc, err)
+
+	case "replace":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		doc, _, err = remove(doc, path)
+		if err != nil {
+			return op.wrap(nil, err)
+		}
+		return op.wrap(add(doc, path, value))
+
+	case "move":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Path != op.From && strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("cannot move %q into one of its children", op.From)
+		}
+		if len(from) == 0 {
+			return nil, errors.New("cannot move the whole document")
+		}
+		doc, value, err := remove(doc, from)
+		if err != nil {
+			return nil, fmt.Errorf("from %q does not exist", op.From)
+		}
+		return op.wrap(add(doc, path, value))
+
+	case "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, fmt.Errorf("from %q does not exist", op.From)
+		}
+		return op.wrap(add(doc, path, deepCopy(value)))
+
+	case "test":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		actual, err := get(doc, path)
+		if err != nil {
+			return op.wrap(nil, err)
+		}
+		if !reflect.DeepEqual(actual, value) {
+			return nil, &Error{Message: fmt.Sprintf("value at %q is not equal to the test value", op.Path), err: ErrTestFailed}
+		}
+		return doc, nil
+
+	default:
+		return nil, fmt.Errorf("unknown op %q", op.Op)
+	}
+}
+
+// value 解析操作中的 value, add, replace 和 test 操作必须提供
+func (op Operation) value() (interface{}, error) {
+	if op.Value == nil {
+		return nil, fmt.Errorf("%s operation must have a value", op.Op)
+	}
+
+	var value interface{}
+	err := json.Unmarshal(op.Value, &value)
+	if err != nil {
+		return nil, fmt.Errorf("%s operation has an invalid value", op.Op)
+	}
+
+	return value, nil
+}
+
+// wrap 将路径不存在的错误转换为包含路径的错误信息
+func (op Operation) wrap(doc interface{}, err error) (interface{}, error) {
+	if errors.Is(err, errNotFound) {
+		return nil, fmt.Errorf("path %q does not exist", op.Path)
+	}
+
+	return doc, err
+}
+
+// parsePointer 解析 JSON Pointer (RFC 6901), 空字符串表示整个文档
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// arrayIndex 解析数组下标, 不允许前导零; size 为允许的最大下标加一
+func arrayIndex(token string, size int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, errNotFound
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i >= size {
+		return 0, errNotFound
+	}
+
+	return i, nil
+}
+
+func get(node interface{}, tokens []string) (interface{}, error) {
+	for _, token := range tokens {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, errNotFound
+			}
+			node = child
+		case []interface{}:
+			i, err := arrayIndex(token, len(n))
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, errNotFound
+		}
+	}
+
+	return node, nil
+}
+
+// add 在 tokens 指向的位置添加值, 数组下标为 - 时追加到末尾
+func add(node interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	token, last := tokens[0], len(tokens) == 1
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if last {
+			n[token] = value
+			return n, nil
+		}
+
+		child, ok := n[token]
+		if !ok {
+			return nil, errNotFound
+		}
+
+		child, err := add(child, tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = child
+		return n, nil
+
+	case []interface{}:
+		if last {
+			i := len(n)
+			if token != "-" {
+				var err error
+				i, err = arrayIndex(token, len(n)+1)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		}
+
+		i, err := arrayIndex(token, len(n))
+		if err != nil {
+			return nil, err
+		}
+
+		child, err := add(n[i], tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = child
+		return n, nil
+
+	default:
+		return nil, errNotFound
+	}
+}
+
+// remove 删除 tokens 指向的值, 返回新的文档和被删除的值
+func remove(node interface{}, tokens []string) (interface{}, interface{}, error) {
+	token, last := tokens[0], len(tokens) == 1
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[token]
+		if !ok {
+			return nil, nil, errNotFound
+		}
+
+		if last {
+			delete(n, token)
+			return n, child, nil
+		}
+
+		child, removed, err := remove(child, tokens[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		n[token] = child
+		return n, removed, nil
+
+	case []interface{}:
+		i, err := arrayIndex(token, len(n))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if last {
+			removed := n[i]
+			return append(n[:i], n[i+1:]...), removed, nil
+		}
+
+		child, removed, err := remove(n[i], tokens[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		n[i] = child
+		return n, removed, nil
+
+	default:
+		return nil, nil, errNotFound
+	}
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			m[key] = deepCopy(child)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, child := range v {
+			s[i] = deepCopy(child)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+// MergePatch 将 JSON Merge Patch 应用到 target 上并返回新的文档
+// patch 中值为 null 的字段会从 target 中删除, 不是对象的 patch 会替换整个文档
+func MergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+			continue
+		}
+		t[key] = MergePatch(t[key], value)
+	}
+
+	return t
+}
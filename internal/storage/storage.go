@@ -0,0 +1,20 @@
+// Package storage 提供保存上传文件的存储后端, 包括本地文件系统和兼容 S3 的对象存储
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrInvalidKey 对象的 key 为空或者包含 .. 等不安全的路径
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Storage 保存上传文件的存储后端, key 使用 / 分隔, 例如 posters/1/abc/small.jpg
+type Storage interface {
+	// Put 保存对象, 已经存在时覆盖
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Delete 删除对象, 对象不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// URL 返回对象的公开访问地址
+	URL(key string) string
+}
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS movie_revisions
+(
+    movie_id   bigint                      NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version    integer                     NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id    bigint                      REFERENCES users ON DELETE SET NULL,
+    title      text                        NOT NULL,
+    year       integer                     NOT NULL,
+    runtime    integer                     NOT NULL,
+    genres     text[]                      NOT NULL,
+    PRIMARY KEY (movie_id, version)
+);
+
+-- Record the current state of existing movies as their first known revision
+INSERT INTO movie_revisions (movie_id, version, created_at, title, year, runtime, genres)
+SELECT id, version, created_at, title, year, runtime, genres
+FROM movies
+ON CONFLICT DO NOTHING;
\ No newline at end of file
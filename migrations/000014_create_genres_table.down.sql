@@ -0,0 +1,3 @@
+-- 电影中已经规范化的类型无法还原
+DELETE FROM permissions WHERE code = 'genres:write';
+DROP TABLE IF EXISTS genres;
\ No newline at end of file
@@ -0,0 +1,99 @@
+CREATE TABLE IF NOT EXISTS genres
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    slug       text UNIQUE                 NOT NULL,
+    name       text                        NOT NULL,
+    aliases    text[]                      NOT NULL DEFAULT '{}',
+    version    integer                     NOT NULL DEFAULT 1
+);
+
+INSERT INTO genres (slug, name, aliases)
+VALUES ('action', 'Action', '{}'),
+       ('adventure', 'Adventure', '{}'),
+       ('animation', 'Animation', '{animated,cartoon}'),
+       ('biography', 'Biography', '{biopic}'),
+       ('comedy', 'Comedy', '{}'),
+       ('crime', 'Crime', '{}'),
+       ('documentary', 'Documentary', '{doc}'),
+       ('drama', 'Drama', '{}'),
+       ('family', 'Family', '{}'),
+       ('fantasy', 'Fantasy', '{}'),
+       ('history', 'History', '{historical}'),
+       ('horror', 'Horror', '{}'),
+       ('musical', 'Musical', '{music}'),
+       ('mystery', 'Mystery', '{}'),
+       ('romance', 'Romance', '{romantic}'),
+       ('sci-fi', 'Science Fiction', '{scifi,sf,science-fiction}'),
+       ('thriller', 'Thriller', '{suspense}'),
+       ('war', 'War', '{}'),
+       ('western', 'Western', '{}')
+ON CONFLICT (slug) DO NOTHING;
+
+-- 已有电影中的每个类型和对应的 slug: 去掉常见的重音符号后小写, 连续的非字母数字字符替换为 -
+-- matched 为类型在登记表中的 slug
+CREATE TEMPORARY TABLE existing_genres AS
+SELECT genre,
+       trim(BOTH '-' FROM regexp_replace(lower(translate(trim(genre),
+                                                         'ÁÀÂÄÃÅáàâäãåÉÈÊËéèêëÍÌÎÏíìîïÓÒÔÖÕóòôöõÚÙÛÜúùûüÑñÇçÝýÿ',
+                                                         'AAAAAAaaaaaaEEEEeeeeIIIIiiiiOOOOOoooooUUUUuuuuNnCcYyy')),
+                                         '[^a-z0-9]+', '-', 'g')) AS slug,
+       NULL::text AS matched
+FROM (SELECT DISTINCT unnest(genres) AS genre FROM movies) AS distinct_genres;
+
+-- 能按 slug, 名称或别名识别的类型使用登记表中的 slug
+UPDATE existing_genres
+SET matched = (SELECT genres.slug
+               FROM genres
+               WHERE genres.slug = existing_genres.slug
+                  OR lower(genres.name) = lower(trim(existing_genres.genre))
+                  OR lower(trim(existing_genres.genre)) = ANY (genres.aliases)
+                  OR existing_genres.slug = ANY (genres.aliases)
+               ORDER BY genres.id
+               LIMIT 1);
+
+-- 没有字母或数字可以用作 slug 的类型 (例如 "科幻") 使用 genre-<n>
+UPDATE existing_genres
+SET slug = 'genre-' || numbered.n
+FROM (SELECT lower(trim(genre)) AS name, dense_rank() OVER (ORDER BY lower(trim(genre))) AS n
+      FROM existing_genres
+      WHERE slug = '' AND matched IS NULL) AS numbered
+WHERE existing_genres.slug = ''
+  AND existing_genres.matched IS NULL
+  AND lower(trim(existing_genres.genre)) = numbered.name;
+
+-- 无法识别的类型加入登记表, slug 相同的类型合并, 原文作为名称, 其他写法作为别名
+INSERT INTO genres (slug, name, aliases)
+SELECT grouped.slug,
+       grouped.names[1],
+       ARRAY(SELECT DISTINCT lower(alias)
+             FROM unnest(grouped.names[2:]) AS alias
+             WHERE lower(alias) <> lower(grouped.names[1]))
+FROM (SELECT slug, array_agg(DISTINCT trim(genre) ORDER BY trim(genre)) AS names
+      FROM existing_genres
+      WHERE matched IS NULL
+      GROUP BY slug) AS grouped
+ON CONFLICT (slug) DO NOTHING;
+
+UPDATE existing_genres
+SET matched = slug
+WHERE matched IS NULL;
+
+-- 将电影的类型替换为标准 slug, 保持原有顺序并去掉替换后重复的类型, 不会删除任何类型
+UPDATE movies
+SET genres = normalized.genres
+FROM (SELECT movies.id, array_agg(mapped.slug ORDER BY mapped.ord) AS genres
+      FROM movies
+      CROSS JOIN LATERAL (SELECT coalesce(existing_genres.matched, original.genre) AS slug, min(original.ord) AS ord
+                          FROM unnest(movies.genres) WITH ORDINALITY AS original(genre, ord)
+                          LEFT JOIN existing_genres ON existing_genres.genre = original.genre
+                          GROUP BY 1) AS mapped
+      GROUP BY movies.id) AS normalized
+WHERE movies.id = normalized.id
+  AND movies.genres IS DISTINCT FROM normalized.genres;
+
+DROP TABLE existing_genres;
+
+-- 管理类型登记表的权限
+INSERT INTO permissions (code)
+VALUES ('genres:write');
\ No newline at end of file
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS watchlist
+(
+    user_id  bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint                      NOT NULL REFERENCES movies ON DELETE CASCADE,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS watchlist_movie_id_idx ON watchlist (movie_id);
+
+CREATE TABLE IF NOT EXISTS watched_movies
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id    bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id   bigint                      NOT NULL REFERENCES movies ON DELETE CASCADE,
+    watched_on date                        NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS watched_movies_user_id_idx ON watched_movies (user_id, watched_on);
\ No newline at end of file
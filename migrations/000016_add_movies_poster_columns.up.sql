@@ -0,0 +1,3 @@
+ALTER TABLE movies
+    ADD COLUMN IF NOT EXISTS poster_key text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS poster_urls jsonb NOT NULL DEFAULT '{}';
\ No newline at end of file
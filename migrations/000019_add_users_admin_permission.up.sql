@@ -0,0 +1,3 @@
+-- 管理用户账号和权限的权限
+INSERT INTO permissions (code)
+VALUES ('users:admin');
\ No newline at end of file
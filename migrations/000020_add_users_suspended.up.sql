@@ -0,0 +1,3 @@
+-- 被管理员停用的账号, 不能通过激活流程恢复
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS suspended boolean NOT NULL DEFAULT false;
\ No newline at end of file